@@ -3,12 +3,14 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 type data struct {
@@ -20,27 +22,154 @@ type discoveryDevice struct {
 	DeviceType  string `json:"{#DEVICE_TYPE}"`
 	DeviceAlias string `json:"{#DEVICE_ALIAS}"`
 	Present     string `json:"{#PRESENT}"`
+	Maintenance string `json:"{#MAINTENANCE},omitempty"`
+
+	// ids are further names a maintenance entry may use for the device.
+	ids []string
 }
 
 func main() {
+	// Global flags come before the command. They are read before the
+	// configuration, whose values are the defaults of the command flags.
+	configFlag := flag.String("config", os.Getenv("ZABBIX_ADAPTEC_CONFIG"), "configuration file (default "+defaultConfigPath+")")
+	arcconfFlag := flag.String("arcconf", "", "arcconf binary, overrides the configuration")
+	timeoutFlag := flag.Int("timeout", -1, "arcconf timeout in seconds, overrides the configuration")
+	cacheTTLFlag := flag.Int("cache-ttl", -1, "inventory cache TTL in seconds, overrides the configuration")
+	flag.Parse()
+	args := flag.Args()
+
+	configPath := *configFlag
+	// Under sudo the configuration and arcconf are root's choice alone, they
+	// name the programs run as root.
+	if privileged() && (len(configPath) > 0 || len(*arcconfFlag) > 0) {
+		fmt.Printf("Cannot use -config, -arcconf or ZABBIX_ADAPTEC_CONFIG under sudo")
+		os.Exit(1)
+	}
+	// config validate reports the problems of a broken file itself.
+	if err := loadConfig(configPath); err != nil && !(len(args) > 1 && args[0] == "config" && args[1] == "validate") {
+		fmt.Printf("Cannot read configuration\n - %v", err)
+		os.Exit(1)
+	}
+	if len(*arcconfFlag) > 0 {
+		cfg.Arcconf = *arcconfFlag
+	}
+	if *timeoutFlag >= 0 {
+		cfg.Timeout = *timeoutFlag
+	}
+	if *cacheTTLFlag >= 0 {
+		cfg.CacheTTL = *cacheTTLFlag
+	}
+
 	discoveryCommand := flag.NewFlagSet("discover", flag.ExitOnError)
 	statsCommand := flag.NewFlagSet("stats", flag.ExitOnError)
 
-	discoveryDeviceType := discoveryCommand.String("type", "", "device type {ad, ld, pd} (Required)")
+	discoveryDeviceType := discoveryCommand.String("type", "", "device type {ad, ld, pd, task, cn, phy} (Required)")
+	discoveryIdentity := discoveryCommand.String("id", pdIdentityScheme, "physical device identity {location, serial, wwn}")
 
-	statsDeviceType := statsCommand.String("type", "", "device type {ad, ld, pd} (Required)")
+	var discoveryInclude, discoveryExclude filterList
+	discoveryCommand.Var(&discoveryInclude, "include", `Only devices matching a filter like "type=pd,state=Online" (repeatable)`)
+	discoveryCommand.Var(&discoveryExclude, "exclude", `Skip devices matching a filter like "controller=2" (repeatable)`)
+
+	statsDeviceType := statsCommand.String("type", "", "device type {ad, ld, pd, task, cn, phy} (Required)")
 	statsDeviceName := statsCommand.String("name", "", `Device "name" to get stats (Required)`)
+	statsBackend := statsCommand.String("backend", "auto", "arcconf output backend {auto, json, text}")
+	statsExtra := statsCommand.Bool("extra", true, `Keep unrecognized arcconf keys in the "extra" map`)
+	statsFieldName := statsCommand.String("field", "", `Print only this field, by name or JSON pointer like "/delta/medium errors"`)
+	var statsInclude, statsExclude filterList
+	statsCommand.Var(&statsInclude, "include", "Only devices matching the filter (repeatable)")
+	statsCommand.Var(&statsExclude, "exclude", "Skip devices matching the filter (repeatable)")
+
+	logsCommand := flag.NewFlagSet("logs", flag.ExitOnError)
+	logsType := logsCommand.String("type", "", "log type {device, event} (Required)")
+	logsDriveName := logsCommand.String("name", "", `Drive serial number or "Controller N, Device M" (device log only)`)
+	logsFormat := logsCommand.String("format", cfg.format("text", "text", "json"), "event output format {text, json}")
+
+	ledgerCommand := flag.NewFlagSet("ledger", flag.ExitOnError)
+	ledgerFormat := ledgerCommand.String("format", cfg.format("text", "text", "json"), "output format {text, json, inventory}")
+
+	transitionsCommand := flag.NewFlagSet("transitions", flag.ExitOnError)
+	transitionsFormat := transitionsCommand.String("format", cfg.format("json", "json", "text"), "output format {json, text}")
+
+	healthCommand := flag.NewFlagSet("health", flag.ExitOnError)
+	healthFormat := healthCommand.String("format", cfg.format("json", "json", "text"), "output format {json, text}")
+	var healthInclude, healthExclude filterList
+	healthCommand.Var(&healthInclude, "include", "Only check devices matching the filter (repeatable)")
+	healthCommand.Var(&healthExclude, "exclude", "Skip devices matching the filter (repeatable)")
+
+	inventoryCommand := flag.NewFlagSet("inventory", flag.ExitOnError)
+	inventoryFormat := inventoryCommand.String("format", cfg.format("json", "json", "csv"), "output format {json, csv}")
 
-	if len(os.Args) < 2 {
-		fmt.Println("[discovery, stats, check] - required one command")
+	snapshotCommand := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	snapshotFile := snapshotCommand.String("file", "", "snapshot file (default in the state directory)")
+
+	diffCommand := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffFormat := diffCommand.String("format", cfg.format("text", "text", "json"), "output format {text, json}")
+
+	selftestCommand := flag.NewFlagSet("selftest", flag.ExitOnError)
+	selftestFormat := selftestCommand.String("format", cfg.format("text", "text", "json"), "output format {text, json}")
+
+	bundleCommand := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	bundleFile := bundleCommand.String("file", "", "archive path (default adaptec-support-HOST-TIME.tar.gz)")
+	bundlePseudonymize := bundleCommand.Bool("pseudonymize", false, "replace serial numbers and WWNs with stable tokens")
+
+	maintenanceCommand := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	maintenanceType := maintenanceCommand.String("type", "", "device type {ad, ld, pd}")
+	maintenanceName := maintenanceCommand.String("name", "", "Device name; without it the active entries are listed")
+	maintenanceFor := maintenanceCommand.Duration("for", 2*time.Hour, "suppression time")
+	maintenanceReason := maintenanceCommand.String("reason", "", "reason for the maintenance")
+	maintenanceRemove := maintenanceCommand.Bool("remove", false, "remove the maintenance entry")
+
+	notifyCommand := flag.NewFlagSet("notify", flag.ExitOnError)
+	notifyTest := notifyCommand.Bool("test", false, "queue a test notification before delivering")
+
+	daemonCommand := flag.NewFlagSet("daemon", flag.ExitOnError)
+	daemonSocketPath := daemonCommand.String("socket", daemonSocket, "Unix socket to answer queries on")
+	daemonInterval := daemonCommand.Duration("interval", time.Minute, "arcconf polling interval")
+	daemonListen := daemonCommand.String("listen", cfg.HTTP.Listen, "Address for the HTTP JSON API, e.g. 127.0.0.1:9180 (disabled if empty)")
+	daemonTokenFile := daemonCommand.String("token-file", cfg.HTTP.TokenFile, "File holding the bearer token required by the HTTP API")
+
+	if len(args) < 1 {
+		fmt.Println("[discovery, stats, logs, spares, ledger, transitions, health, inventory, snapshot, diff, support-bundle, maintenance, notify, daemon, config, fields, version, selftest, check] - required one command")
 		os.Exit(1)
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "discovery":
-		discoveryCommand.Parse(os.Args[2:])
+		discoveryCommand.Parse(args[1:])
 	case "stats":
-		statsCommand.Parse(os.Args[2:])
+		statsCommand.Parse(args[1:])
+	case "logs":
+		logsCommand.Parse(args[1:])
+	case "spares":
+		sparesReport()
+	case "ledger":
+		ledgerCommand.Parse(args[1:])
+	case "transitions":
+		transitionsCommand.Parse(args[1:])
+	case "health":
+		healthCommand.Parse(args[1:])
+	case "inventory":
+		inventoryCommand.Parse(args[1:])
+	case "snapshot":
+		snapshotCommand.Parse(args[1:])
+	case "diff":
+		diffCommand.Parse(args[1:])
+	case "support-bundle":
+		bundleCommand.Parse(args[1:])
+	case "maintenance":
+		maintenanceCommand.Parse(args[1:])
+	case "notify":
+		notifyCommand.Parse(args[1:])
+	case "daemon":
+		daemonCommand.Parse(args[1:])
+	case "config":
+		configCommand(args[1:], configPath)
+	case "fields":
+		fieldsReport()
+	case "version":
+		versionInfo()
+	case "selftest":
+		selftestCommand.Parse(args[1:])
 	case "check":
 		checkArcconf()
 	default:
@@ -49,7 +178,9 @@ func main() {
 	}
 
 	if discoveryCommand.Parsed() {
-		metricChoices := map[string]bool{"ad": true, "ld": true, "pd": true}
+		pdIdentityScheme = *discoveryIdentity
+		setFilters(discoveryInclude, discoveryExclude)
+		metricChoices := map[string]bool{"ad": true, "ld": true, "pd": true, "task": true, "cn": true, "phy": true}
 		if _, validChoice := metricChoices[*discoveryDeviceType]; !validChoice {
 			discoveryCommand.PrintDefaults()
 			os.Exit(1)
@@ -61,6 +192,12 @@ func main() {
 			ldDiscovery()
 		case "pd":
 			pdDiscovery()
+		case "task":
+			taskDiscovery()
+		case "cn":
+			cnDiscovery()
+		case "phy":
+			phyDiscovery()
 		default:
 			discoveryCommand.PrintDefaults()
 			os.Exit(1)
@@ -68,7 +205,11 @@ func main() {
 	}
 
 	if statsCommand.Parsed() {
-		metricChoices := map[string]bool{"ad": true, "ld": true, "pd": true}
+		collectBackend = *statsBackend
+		captureExtra = *statsExtra
+		statsField = *statsFieldName
+		setFilters(statsInclude, statsExclude)
+		metricChoices := map[string]bool{"ad": true, "ld": true, "pd": true, "task": true, "cn": true, "phy": true}
 		if _, validChoice := metricChoices[*statsDeviceType]; !validChoice {
 			statsCommand.PrintDefaults()
 			os.Exit(0)
@@ -84,11 +225,74 @@ func main() {
 			ldStats(*statsDeviceName)
 		case "pd":
 			pdStats(*statsDeviceName)
+		case "task":
+			taskStats(*statsDeviceName)
+		case "cn":
+			cnStats(*statsDeviceName)
+		case "phy":
+			phyStats(*statsDeviceName)
 		default:
 			statsCommand.PrintDefaults()
 			os.Exit(0)
 		}
 	}
+
+	if logsCommand.Parsed() {
+		switch *logsType {
+		case "device":
+			logsDevice(*logsDriveName)
+		case "event":
+			logsEvent(*logsFormat)
+		default:
+			logsCommand.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	if ledgerCommand.Parsed() {
+		ledgerReport(*ledgerFormat)
+	}
+
+	if transitionsCommand.Parsed() {
+		transitionsReport(*transitionsFormat)
+	}
+
+	if healthCommand.Parsed() {
+		setFilters(healthInclude, healthExclude)
+		healthReport(*healthFormat)
+	}
+
+	if inventoryCommand.Parsed() {
+		inventoryReport(*inventoryFormat)
+	}
+
+	if snapshotCommand.Parsed() {
+		takeSnapshot(*snapshotFile)
+	}
+
+	if diffCommand.Parsed() {
+		diffSnapshots(diffCommand.Args(), *diffFormat)
+	}
+
+	if selftestCommand.Parsed() {
+		selftestReport(*selftestFormat)
+	}
+
+	if bundleCommand.Parsed() {
+		supportBundle(*bundleFile, *bundlePseudonymize)
+	}
+
+	if maintenanceCommand.Parsed() {
+		maintenance(*maintenanceType, *maintenanceName, *maintenanceFor, *maintenanceReason, *maintenanceRemove)
+	}
+
+	if notifyCommand.Parsed() {
+		notifyWebhooks(*notifyTest)
+	}
+
+	if daemonCommand.Parsed() {
+		daemon(*daemonSocketPath, *daemonInterval, *daemonListen, *daemonTokenFile)
+	}
 }
 
 func noDevice() {
@@ -98,22 +302,24 @@ func noDevice() {
 	fmt.Print(string(r))
 }
 
+// checkArcconf prints 1 when the host can be monitored, either because lspci
+// found no Adaptec controller or because every selftest check passes. A
+// failed lspci is a failure, not an empty host.
 func checkArcconf() {
-	controllers, err := controllersCount()
-	if err != nil {
-		fmt.Printf("Cannot check lspci adaptec controllers\n - %v", err)
-		os.Exit(1)
+	results, controllers := selftest()
+	for _, result := range results {
+		if result.Check == "controllers" && result.Passed && controllers < 1 {
+			fmt.Print(1)
+			return
+		}
 	}
-
-	if controllers > 0 {
-		_, binErr := getBin("arcconf")
-		if binErr != nil {
+	for _, result := range results {
+		if !result.Passed {
 			fmt.Print(0)
+			return
 		}
-		fmt.Print(1)
-	} else {
-		fmt.Print(1)
 	}
+	fmt.Print(1)
 }
 
 func controllersCount() (int, error) {
@@ -132,8 +338,11 @@ func controllersCount() (int, error) {
 	scanner := bufio.NewScanner(out)
 
 	for scanner.Scan() {
-		//if strings.Contains(scanner.Text(), "Adaptec") {
-		if strings.Contains(scanner.Text(), "Adaptec") && strings.Contains(scanner.Text(), "RAID bus controller") {
+		matched := true
+		for _, match := range cfg.lspciMatch() {
+			matched = matched && strings.Contains(scanner.Text(), match)
+		}
+		if matched {
 			counter++
 		}
 	}
@@ -141,9 +350,14 @@ func controllersCount() (int, error) {
 }
 
 func getBin(binFile string) (string, error) {
-	location := []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/local/bin", "/usr/local/sbin"}
+	if binFile == "arcconf" && len(cfg.Arcconf) > 0 {
+		if _, err := os.Stat(cfg.Arcconf); err != nil {
+			return "", err
+		}
+		return cfg.Arcconf, nil
+	}
 
-	for _, path := range location {
+	for _, path := range cfg.searchPaths() {
 		lookup := path + "/" + binFile
 		fileInfo, err := os.Stat(path + "/" + binFile)
 		if err != nil {
@@ -155,3 +369,16 @@ func getBin(binFile string) (string, error) {
 	}
 	return "", fmt.Errorf("Not found: '%v'", binFile)
 }
+
+func arcconf(args ...string) ([]byte, error) {
+	bin, err := getBin("arcconf")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Timeout < 1 {
+		return exec.Command(bin, args...).Output()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+	return exec.CommandContext(ctx, bin, args...).Output()
+}
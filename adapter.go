@@ -3,71 +3,54 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 )
 
 type adInfo struct {
-	ControllerStatus           string `json:"controller status"`
-	ChannelDescription         string `json:"channel description"`
-	ControllerModel            string `json:"controller model"`
-	ControllerSerialNumber     string `json:"controller serial number"`
-	ControllerWorldWideName    string `json:"controller world wide name"`
-	ControllerAlarm            string `json:"controller alarm"`
-	Temperature                string `json:"temperature"`
-	InstalledMemory            string `json:"installed memory"`
-	GlobalTaskPriority         string `json:"global task priority"`
-	PerformanceMode            string `json:"performance mode"`
-	StayawakePeriod            string `json:"stayawake period"`
-	DefunctDiskDriveCount      int    `json:"defunct disk drive count"`
-	LogicalDevicesFailed       int    `json:"logical devices failed"`
-	LogicalDevicesTotal        int    `json:"logical devices total"`
-	LogicalDevicesDegraded     int    `json:"logical devices degraded"`
-	NCQStatus                  string `json:"ncq status"`
-	Copyback                   string `json:"copyback"`
-	AutomaticFailover          string `json:"automatic failover"`
-	BackgroundConsistencyCheck string `json:"background consistency check"`
-	BIOS                       string `json:"bios"`
-	Firmware                   string `json:"firmware"`
-	Driver                     string `json:"driver"`
-	Status                     string `json:"status"`
-	BatteryPresent             string `json:"battery present"`
+	Controller                 int               `json:"controller"`
+	ControllerStatus           string            `json:"controller status"`
+	ChannelDescription         string            `json:"channel description"`
+	ControllerModel            string            `json:"controller model"`
+	ControllerSerialNumber     string            `json:"controller serial number"`
+	ControllerWorldWideName    string            `json:"controller world wide name"`
+	ControllerAlarm            string            `json:"controller alarm"`
+	Temperature                string            `json:"temperature"`
+	TemperatureC               float64           `json:"temperature c"`
+	InstalledMemory            string            `json:"installed memory"`
+	GlobalTaskPriority         string            `json:"global task priority"`
+	PerformanceMode            string            `json:"performance mode"`
+	StayawakePeriod            string            `json:"stayawake period"`
+	DefunctDiskDriveCount      int               `json:"defunct disk drive count"`
+	LogicalDevicesFailed       int               `json:"logical devices failed"`
+	LogicalDevicesTotal        int               `json:"logical devices total"`
+	LogicalDevicesDegraded     int               `json:"logical devices degraded"`
+	NCQStatus                  string            `json:"ncq status"`
+	Copyback                   string            `json:"copyback"`
+	AutomaticFailover          string            `json:"automatic failover"`
+	BackgroundConsistencyCheck string            `json:"background consistency check"`
+	BIOS                       string            `json:"bios"`
+	Firmware                   string            `json:"firmware"`
+	Driver                     string            `json:"driver"`
+	Status                     string            `json:"status"`
+	BatteryPresent             string            `json:"battery present"`
+	Extra                      map[string]string `json:"extra,omitempty"`
+	Maintenance                bool              `json:"maintenance"`
 }
 
 func adDiscovery() {
-	deviceType := "AD"
 	adapters := []discoveryDevice{}
 
-	controllers, err := controllersCount()
-	if err != nil {
-		fmt.Printf("Cannot check lspci adaptec controllers\n - %v", err)
-		os.Exit(1)
-	}
-
-	if controllers > 0 {
-		bin, binErr := getBin("arcconf")
-		if binErr != nil {
-			fmt.Printf("arcconf - not found in system PATH\n - %v", err)
-			os.Exit(0)
-		}
-
-		for controller := 1; controller <= controllers; controller++ {
-			args := []string{"getconfig", strconv.Itoa(controller), deviceType, "nologs"}
-			cmd := exec.Command(bin, args...)
-			out, err := cmd.Output()
-			if err != nil {
-				fmt.Printf("Error %v", err)
-			}
-
-			ad := discoveryDevice{}
-			for _, adstat := range strings.Split(string(out), "\n") {
-				ad.adDiscoveryParser(adstat, controller)
-			}
-			adapters = append(adapters, ad)
-		}
+	inv := inventoryOrExit("AD")
+	for _, ad := range inv.Controllers {
+		adapters = append(adapters, discoveryDevice{
+			DeviceID:    strconv.Itoa(ad.Controller),
+			DeviceType:  "AD",
+			DeviceAlias: ad.ControllerModel,
+			Present:     "Present",
+		})
 	}
+	maintenanceFlags(adapters)
 	data := data{Data: adapters}
 
 	//r, _ := json.MarshalIndent(data, "", "  ")
@@ -76,65 +59,34 @@ func adDiscovery() {
 }
 
 func adStats(adController string) {
-	deviceType := "AD"
-
-	controllers, cErr := controllersCount()
-	if cErr != nil {
-		fmt.Printf("Cannot check lspci adaptec controllers: %v", cErr)
-		os.Exit(1)
-	}
-
-	bin, binErr := getBin("arcconf")
-	if binErr != nil {
-		fmt.Println("Arcconf not found:", binErr)
-		os.Exit(0)
-	}
-
 	ads := map[string]adInfo{}
 
-	for controller := 1; controller <= controllers; controller++ {
-		args := []string{"getconfig", strconv.Itoa(controller), deviceType, "nologs"}
-		cmd := exec.Command(bin, args...)
-		out, err := cmd.Output()
-		if err != nil {
-			fmt.Printf("Error %v", err)
-		}
-
-		ad := adInfo{Status: "NotPresent"}
-
-		for _, adstat := range strings.Split(string(out), "\n") {
-			ad.adParserInfo(adstat)
-		}
-
-		ads[strconv.Itoa(controller)] = ad
+	inv := inventoryOrExit("AD")
+	for _, ad := range inv.Controllers {
+		ads[strconv.Itoa(ad.Controller)] = ad
 	}
 
-	if _, ok := ads[adController]; ok {
+	if ad, ok := ads[adController]; ok {
+		unsupported(len(ad.ControllerModel) < 1)
+		ad.Maintenance = underMaintenance(activeMaintenance(), "AD", adController)
 		//r, _ := json.MarshalIndent(devices[ldName], "", "  ")
-		r, _ := json.Marshal(ads[adController])
-		fmt.Print(string(r))
+		printStats(ad)
 	} else {
-		fmt.Printf("AD not exist %v", adController)
-		os.Exit(1)
-	}
-}
-
-func (d *discoveryDevice) adDiscoveryParser(line string, controller int) error {
-	split := strings.Split(line, " : ")
-	match := strings.ToLower(strings.TrimSpace(split[0]))
-	if match == "controller model" {
-		model := strings.TrimSpace(split[1])
-		d.DeviceAlias = model
+		unsupported(true)
+		notExist("AD", adController)
 	}
-	d.DeviceID = strconv.Itoa(controller)
-	d.Present = "Present"
-	d.DeviceType = "AD"
-	return nil
 }
 
 func (ad *adInfo) adParserInfo(line string) error {
 	split := strings.Split(line, " : ")
 	match := strings.ToLower(strings.TrimSpace(split[0]))
+	if len(split) < 2 {
+		// Only the battery section header means anything without a value.
+		if match == "controller zmm information" || match == "battery" {
+			ad.BatteryPresent = "True"
+		}
+		return nil
+	}
 	switch match {
 	case "controller status":
 		ad.ControllerStatus = strings.TrimSpace(split[1])
@@ -150,6 +102,7 @@ func (ad *adInfo) adParserInfo(line string) error {
 		ad.ControllerAlarm = strings.TrimSpace(split[1])
 	case "temperature":
 		ad.Temperature = strings.TrimSpace(split[1])
+		ad.TemperatureC = parseTemperature(ad.Temperature)
 	case "installed memory":
 		ad.InstalledMemory = strings.TrimSpace(split[1])
 	case "global task priority":
@@ -170,6 +123,9 @@ func (ad *adInfo) adParserInfo(line string) error {
 			}
 			r = append(r, d)
 		}
+		if len(r) < 3 {
+			return nil
+		}
 		ad.LogicalDevicesTotal = r[0]
 		ad.LogicalDevicesFailed = r[1]
 		ad.LogicalDevicesDegraded = r[2]
@@ -189,10 +145,10 @@ func (ad *adInfo) adParserInfo(line string) error {
 		ad.Driver = strings.TrimSpace(split[1])
 	case "status":
 		ad.Status = strings.TrimSpace(split[1])
-	case "controller zmm information":
-		ad.BatteryPresent = "True"
 	case "battery":
 		ad.BatteryPresent = "True"
+	default:
+		ad.Extra = extraField(ad.Extra, match, split)
 	}
 	return nil
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// toolVersion is set at build time with -ldflags "-X main.toolVersion=...".
+var toolVersion = "dev"
+
+const pseudonymKey = "pseudonym.key"
+
+type bundleFile struct {
+	Name string
+	Data []byte
+}
+
+// supportCommands lists every arcconf call this tool makes.
+func supportCommands(controllers int) [][]string {
+	commands := [][]string{{"version"}}
+	commands = append(commands, configCommands(controllers)...)
+	for controller := 1; controller <= controllers; controller++ {
+		id := strconv.Itoa(controller)
+		for _, deviceType := range []string{"AD", "LD", "PD"} {
+			commands = append(commands, []string{"getconfigjson", id, deviceType})
+		}
+		for _, logType := range []string{"device", "dead", "event"} {
+			commands = append(commands, []string{"getlogs", id, logType})
+		}
+	}
+	return commands
+}
+
+// Identifiers are also picked out of the raw files, a drive that is only in
+// a log or an unparsed JSON key must not leak either. The first group is the
+// name, the second the value.
+var (
+	identifierAttribute = regexp.MustCompile(`(?i)\b(\w*(?:serialnumber|wwn|sasaddress))="([^"]*)"`)
+	identifierLine      = regexp.MustCompile(`(?im)^[ \t]*([\w ,-]*(?:serial number|world[- ]wide name|sas address|wwn))[ \t]+:[ \t]*(\S+)[ \t]*$`)
+	identifierJSON      = regexp.MustCompile(`(?i)"([\w -]*(?:serial(?: ?number)?|world[- ]?wide ?name|wwn|sas ?address))"\s*:\s*"([^"]*)"`)
+)
+
+// pseudonymizer replaces serial numbers and WWNs with stable tokens. The
+// tokens are keyed with a secret kept in the state directory, so the same
+// drive gets the same token in every bundle of this host but the real
+// number cannot be recovered from it.
+func pseudonymizer(inv inventory, files []bundleFile) (*strings.Replacer, error) {
+	key := []byte{}
+	raw, err := ioutil.ReadFile(filepath.Join(stateDir, pseudonymKey))
+	if err == nil {
+		key = raw
+	} else if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(stateDir, pseudonymKey), key, 0600); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	tokens := map[string]string{}
+	add := func(prefix string, value string) {
+		value = strings.TrimSpace(value)
+		if len(value) < 4 {
+			return
+		}
+		sum := sha256.Sum256(append(append([]byte{}, key...), strings.ToUpper(value)...))
+		token := prefix + hex.EncodeToString(sum[:6])
+		tokens[value] = token
+		tokens[strings.ToUpper(value)] = token
+		tokens[strings.ToLower(value)] = token
+	}
+	for _, ad := range inv.Controllers {
+		add("SN-", ad.ControllerSerialNumber)
+		add("WWN-", ad.ControllerWorldWideName)
+	}
+	for _, ld := range inv.LogicalDevices {
+		for _, member := range ld.Members {
+			add("SN-", member)
+		}
+	}
+	for _, pd := range inv.PhysicalDevices {
+		add("SN-", pd.SerialNumber)
+		add("WWN-", pd.WWN)
+	}
+	for _, file := range files {
+		for _, pattern := range []*regexp.Regexp{identifierAttribute, identifierLine, identifierJSON} {
+			for _, match := range pattern.FindAllStringSubmatch(string(file.Data), -1) {
+				prefix := "WWN-"
+				if strings.Contains(strings.ToLower(match[1]), "serial") {
+					prefix = "SN-"
+				}
+				if value := strings.TrimSpace(match[2]); !strings.ContainsAny(value, " \t") {
+					add(prefix, value)
+				}
+			}
+		}
+	}
+
+	// Longer values first, so a serial that contains another one is
+	// replaced as a whole.
+	values := []string{}
+	for value := range tokens {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	pairs := []string{}
+	for _, value := range values {
+		pairs = append(pairs, value, tokens[value])
+	}
+	return strings.NewReplacer(pairs...), nil
+}
+
+func supportBundle(path string, pseudonymize bool) {
+	inv, err := collectInventory()
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+	host, _ := os.Hostname()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	files := []bundleFile{}
+	add := func(name string, data []byte) {
+		files = append(files, bundleFile{Name: name, Data: data})
+	}
+	addJSON := func(name string, v interface{}) {
+		r, _ := json.MarshalIndent(v, "", "  ")
+		add(name, r)
+	}
+
+	addJSON("version.json", map[string]interface{}{
+		"tool version": toolVersion,
+		"arcconf":      detectVersion(),
+		"created":      now.Format(time.RFC3339),
+	})
+	raw, _ := rawOutputs(supportCommands(len(inv.Controllers)))
+	commands := []string{}
+	for command := range raw {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		add("arcconf/"+strings.Replace(command, " ", "_", -1)+".txt", []byte(raw[command]))
+	}
+	addJSON("inventory.json", inv)
+	addJSON("health.json", evaluateHealth(inv))
+
+	system := map[string]string{
+		"proc-version":        "/proc/version",
+		"aacraid-version":     "/sys/module/aacraid/version",
+		"smartpqi-version":    "/sys/module/smartpqi/version",
+		"aacraid-srcversion":  "/sys/module/aacraid/srcversion",
+		"smartpqi-srcversion": "/sys/module/smartpqi/srcversion",
+	}
+	names := []string{}
+	for name := range system {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if data, err := ioutil.ReadFile(system[name]); err == nil {
+			add("system/"+name, data)
+		}
+	}
+
+	if pseudonymize {
+		replacer, err := pseudonymizer(inv, files)
+		if err != nil {
+			fmt.Printf("Cannot prepare pseudonyms\n - %v", err)
+			os.Exit(1)
+		}
+		for i := range files {
+			files[i].Data = []byte(replacer.Replace(string(files[i].Data)))
+		}
+	}
+
+	if len(path) < 1 {
+		path = fmt.Sprintf("adaptec-support-%v-%v.tar.gz", host, now.Format("20060102-150405"))
+	}
+	// The archive goes where the user who ran sudo may write, and is theirs.
+	if err := dropPrivileges(); err != nil {
+		fmt.Printf("Cannot drop privileges to write %v\n - %v", path, err)
+		os.Exit(1)
+	}
+	if err := writeBundle(path, "adaptec-support-"+now.Format("20060102-150405"), files, now); err != nil {
+		fmt.Printf("Cannot write support bundle\n - %v", err)
+		os.Exit(1)
+	}
+	fmt.Println(path)
+}
+
+func writeBundle(path string, dir string, files []bundleFile, now time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    dir + "/" + file.Name,
+			Mode:    0644,
+			Size:    int64(len(file.Data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every serial number and WWN in the fixtures, including the drive WD-BBB
+// that only shows up in the dead drive log.
+var knownIdentifiers = []string{
+	"5A1234567", "50000D1109876543",
+	"WD-AAA", "WD-BBB", "WD-CCC", "WD-SPARE",
+	"50014EE2B5D1A2F0", "50014EE2B5D1A2F1", "50014EE2B5D1A2F2", "50014EE2B5D1A2F3",
+}
+
+func TestPseudonymizeRawOutput(t *testing.T) {
+	dir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = dir })
+
+	names, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []bundleFile{}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, bundleFile{Name: filepath.Base(name), Data: data})
+	}
+
+	// No parsed inventory, the identifiers must be found in the files.
+	replacer, err := pseudonymizer(inventory{}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		text := strings.ToUpper(replacer.Replace(string(file.Data)))
+		for _, id := range knownIdentifiers {
+			if strings.Contains(text, id) {
+				t.Errorf("%v still contains %v", file.Name, id)
+			}
+		}
+	}
+
+	// The same identifier gets the same token in every file.
+	again, err := pseudonymizer(inventory{}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := replacer.Replace("WD-BBB"), again.Replace("wd-bbb"); a != b || !strings.HasPrefix(a, "SN-") {
+		t.Errorf("WD-BBB became %v and %v, want one SN- token", a, b)
+	}
+}
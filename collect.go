@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type inventory struct {
+	Collected       int64    `json:"collected"`
+	Controllers     []adInfo `json:"controllers"`
+	LogicalDevices  []ldInfo `json:"logical devices"`
+	PhysicalDevices []pdInfo `json:"physical devices"`
+
+	fromDaemon bool
+}
+
+func getConfig(controller int, deviceType string) (string, error) {
+	args := append([]string{"getconfig", strconv.Itoa(controller), deviceType}, cfg.getconfigArgs()...)
+	out, err := arcconf(args...)
+	return string(out), err
+}
+
+func collectAD(controller int) (adInfo, error) {
+	if useJSON() {
+		if ad, ok := collectADJSON(controller); ok {
+			return ad, nil
+		}
+	}
+
+	out, err := getConfig(controller, "AD")
+	if err != nil {
+		return adInfo{Controller: controller, Status: "NotPresent"}, err
+	}
+	return parseAD(out, controller), nil
+}
+
+func parseAD(out string, controller int) adInfo {
+	ad := adInfo{Controller: controller, Status: "NotPresent"}
+	for _, adstat := range strings.Split(out, "\n") {
+		ad.adParserInfo(profileLine(adstat))
+	}
+	return ad
+}
+
+func collectLDs(controller int) ([]ldInfo, error) {
+	if useJSON() {
+		if lds, ok := collectLDsJSON(controller); ok {
+			return lds, nil
+		}
+	}
+
+	out, err := getConfig(controller, "LD")
+	if err != nil {
+		return nil, err
+	}
+	return parseLDs(out, controller), nil
+}
+
+func parseLDs(out string, controller int) []ldInfo {
+	lds := []ldInfo{}
+	for _, ldinfo := range strings.Split(out, "Logical Device number")[1:] {
+		lines := strings.Split(ldinfo, "\n")
+		ld := ldInfo{Controller: controller, Number: strings.TrimSpace(lines[0])}
+
+		for _, ldstat := range lines[1:] {
+			ld.ldParserInfo(profileLine(ldstat))
+		}
+		lds = append(lds, ld)
+	}
+	return lds
+}
+
+func collectPDs(controller int) ([]pdInfo, error) {
+	if useJSON() {
+		if pds, ok := collectPDsJSON(controller); ok {
+			return pds, nil
+		}
+	}
+
+	out, err := getConfig(controller, "PD")
+	if err != nil {
+		return nil, err
+	}
+	return parsePDs(out, controller), nil
+}
+
+func parsePDs(out string, controller int) []pdInfo {
+	pds := []pdInfo{}
+	for _, pdinfo := range strings.Split(out, "Device #") {
+		pd := pdInfo{Controller: controller}
+
+		for _, pdstat := range strings.Split(pdinfo, "\n") {
+			pd.pdParserInfo(profileLine(pdstat), controller)
+		}
+		if len(pd.State) > 1 {
+			pd.spareType()
+			pd.Identity = pdIdentity{pd.SerialNumber, pd.WWN, pd.DeviceID}
+			pds = append(pds, pd)
+		}
+	}
+	return pds
+}
+
+var pdIdentityScheme = "location"
+
+const inventoryCache = "inventory.json"
+
+var allDeviceTypes = []string{"AD", "LD", "PD"}
+
+func collectInventory() (inventory, error) {
+	return collectDevices(allDeviceTypes...)
+}
+
+// collectDevices runs only the getconfig calls of the given device types.
+func collectDevices(deviceTypes ...string) (inventory, error) {
+	inv := inventory{Collected: time.Now().Unix()}
+	want := map[string]bool{}
+	for _, deviceType := range deviceTypes {
+		want[deviceType] = true
+	}
+
+	controllers, err := controllersCount()
+	if err != nil {
+		return inv, err
+	}
+
+	for controller := 1; controller <= controllers; controller++ {
+		if want["AD"] {
+			ad, err := collectAD(controller)
+			if err != nil {
+				return inv, err
+			}
+			inv.Controllers = append(inv.Controllers, ad)
+		}
+		if want["LD"] {
+			lds, err := collectLDs(controller)
+			if err != nil {
+				return inv, err
+			}
+			inv.LogicalDevices = append(inv.LogicalDevices, lds...)
+		}
+		if want["PD"] {
+			pds, err := collectPDs(controller)
+			if err != nil {
+				return inv, err
+			}
+			inv.PhysicalDevices = append(inv.PhysicalDevices, pds...)
+		}
+	}
+	return inv, nil
+}
+
+// loadInventory returns every device. Only discovery, stats and health
+// apply the filters, the state trackers must see all devices.
+func loadInventory() (inventory, error) {
+	return cachedInventory(allDeviceTypes...)
+}
+
+// loadDevices returns at least the given device types without the filtered
+// devices.
+func loadDevices(deviceTypes ...string) (inventory, error) {
+	inv, err := cachedInventory(deviceTypes...)
+	return filterInventory(inv), err
+}
+
+// cachedInventory asks a running daemon for its copy of the inventory. When
+// no daemon answers it reuses a cached collection younger than the
+// configured TTL, or collects the given device types directly. A backend
+// asked for by name is always collected directly, the daemon and the cache
+// may have used the other one.
+func cachedInventory(deviceTypes ...string) (inventory, error) {
+	if collectBackend == "auto" {
+		if inv, err := queryDaemon(); err == nil {
+			return withoutExtra(inv), nil
+		}
+		if cfg.CacheTTL > 0 {
+			cached := inventory{}
+			if err := loadState(inventoryCache, &cached); err == nil && time.Now().Unix()-cached.Collected < int64(cfg.CacheTTL) {
+				return withoutExtra(cached), nil
+			}
+			// The cache is shared by every item, it keeps the unrecognized
+			// keys even when this run does not want them.
+			extra := captureExtra
+			captureExtra = true
+			inv, err := collectInventory()
+			captureExtra = extra
+			if err != nil {
+				return inv, err
+			}
+			// A cache that cannot be written only costs the next run a collection.
+			saveState(inventoryCache, inv)
+			return withoutExtra(inv), nil
+		}
+	}
+
+	// An excluded controller takes its devices with it, so the controllers
+	// are needed to apply the filters.
+	if len(cfg.Filters.Exclude) > 0 {
+		deviceTypes = append(deviceTypes, "AD")
+	}
+	return collectDevices(deviceTypes...)
+}
+
+// withoutExtra drops the unrecognized keys from a collection made elsewhere
+// when they are not wanted.
+func withoutExtra(inv inventory) inventory {
+	if captureExtra {
+		return inv
+	}
+	for i := range inv.Controllers {
+		inv.Controllers[i].Extra = nil
+	}
+	for i := range inv.LogicalDevices {
+		inv.LogicalDevices[i].Extra = nil
+	}
+	for i := range inv.PhysicalDevices {
+		inv.PhysicalDevices[i].Extra = nil
+	}
+	return inv
+}
+
+func inventoryOrExit(deviceType string) inventory {
+	inv, err := loadDevices(deviceType)
+	if err == nil {
+		return inv
+	}
+	if _, binErr := getBin("arcconf"); binErr != nil {
+		fmt.Printf("arcconf - not found in system PATH\n - %v", binErr)
+		os.Exit(0)
+	}
+	fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+	os.Exit(1)
+	return inv
+}
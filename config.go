@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const defaultConfigPath = "/etc/zabbix-adaptec.json"
+
+type config struct {
+	// Arcconf is the full path of the binary, otherwise it is looked up in
+	// SearchPaths.
+	Arcconf       string   `json:"arcconf"`
+	SearchPaths   []string `json:"search paths"`
+	LspciMatch    []string `json:"lspci match"`
+	GetconfigArgs []string `json:"getconfig args"`
+	// Timeout limits every arcconf call, in seconds.
+	Timeout int `json:"timeout"`
+	// CacheTTL lets commands reuse a collection made by an earlier run for
+	// that many seconds when no daemon is running.
+	CacheTTL int    `json:"cache ttl"`
+	StateDir string `json:"state dir"`
+	Socket   string `json:"socket"`
+	Identity string `json:"pd identity"`
+	// Format is the default of every -format flag that accepts it.
+	Format string `json:"format"`
+
+	Filters  filterConfig  `json:"filters"`
+	Health   healthConfig  `json:"health"`
+	Webhooks webhookConfig `json:"webhooks"`
+	Hooks    hookConfig    `json:"hooks"`
+	Log      logConfig     `json:"log"`
+	HTTP     httpConfig    `json:"http"`
+}
+
+var cfg = config{}
+
+var (
+	defaultSearchPaths   = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/local/bin", "/usr/local/sbin"}
+	defaultLspciMatch    = []string{"Adaptec", "RAID bus controller"}
+	defaultGetconfigArgs = []string{"nologs"}
+)
+
+// privileged tells whether the run has more rights than the user who started
+// it, under sudo or as a setuid binary. Such a user must not choose which
+// programs run or where files are written.
+func privileged() bool {
+	return len(os.Getenv("SUDO_USER")) > 0 || os.Geteuid() != os.Getuid()
+}
+
+// dropPrivileges makes the rest of the run act as the user who started it,
+// before a file is read or written at a path that user gave.
+func dropPrivileges() error {
+	if !privileged() {
+		return nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if os.Geteuid() == 0 && len(os.Getenv("SUDO_UID")) > 0 {
+		var err error
+		if uid, err = strconv.Atoi(os.Getenv("SUDO_UID")); err != nil {
+			return fmt.Errorf("SUDO_UID: %v", err)
+		}
+		if gid, err = strconv.Atoi(os.Getenv("SUDO_GID")); err != nil {
+			return fmt.Errorf("SUDO_GID: %v", err)
+		}
+	}
+	if uid == os.Geteuid() {
+		return nil
+	}
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
+
+// trustedFile refuses a file root reads its programs from when somebody
+// else could have written it.
+func trustedFile(f *os.File) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != 0 || info.Mode()&0022 != 0 {
+		return fmt.Errorf("%v must be owned by root and not writable by group or others", f.Name())
+	}
+	return nil
+}
+
+func readConfigFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := trustedFile(f); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+// loadConfig reads the configuration file. A missing file at the default
+// location is not an error, the built-in defaults are used instead.
+func loadConfig(path string) error {
+	if len(path) < 1 {
+		path = defaultConfigPath
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	raw, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return err
+	}
+
+	if len(cfg.StateDir) > 0 {
+		stateDir = cfg.StateDir
+	}
+	if len(cfg.Socket) > 0 {
+		daemonSocket = cfg.Socket
+	}
+	if len(cfg.Identity) > 0 {
+		pdIdentityScheme = cfg.Identity
+	}
+	return nil
+}
+
+func (c config) searchPaths() []string {
+	if len(c.SearchPaths) < 1 {
+		return defaultSearchPaths
+	}
+	return c.SearchPaths
+}
+
+func (c config) lspciMatch() []string {
+	if len(c.LspciMatch) < 1 {
+		return defaultLspciMatch
+	}
+	return c.LspciMatch
+}
+
+func (c config) getconfigArgs() []string {
+	if c.GetconfigArgs == nil {
+		return defaultGetconfigArgs
+	}
+	return c.GetconfigArgs
+}
+
+func (c config) timeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// format returns the configured output format when the command supports it.
+func (c config) format(builtin string, choices ...string) string {
+	for _, choice := range choices {
+		if choice == c.Format {
+			return c.Format
+		}
+	}
+	return builtin
+}
+
+// validateConfig reads the file strictly and returns every problem found.
+func validateConfig(path string) []string {
+	if len(path) < 1 {
+		path = defaultConfigPath
+	}
+	raw, err := readConfigFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	c := config{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(c.Arcconf) > 0 {
+		if info, err := os.Stat(c.Arcconf); err != nil {
+			problem("arcconf: %v", err)
+		} else if info.IsDir() || info.Mode()&0111 == 0 {
+			problem("arcconf: %v is not executable", c.Arcconf)
+		}
+	}
+	for _, dir := range c.SearchPaths {
+		if !filepath.IsAbs(dir) {
+			problem("search paths: %v is not an absolute path", dir)
+		}
+	}
+	if c.Timeout < 0 || c.CacheTTL < 0 || c.Webhooks.Timeout < 0 || c.Hooks.Timeout < 0 {
+		problem("timeouts and cache ttl must not be negative")
+	}
+	if len(c.Socket) > 0 {
+		if _, err := os.Stat(filepath.Dir(c.Socket)); err != nil {
+			problem("socket: %v", err)
+		}
+	}
+	switch c.Identity {
+	case "", "location", "serial", "wwn":
+	default:
+		problem("pd identity: unknown scheme %q, use location, serial or wwn", c.Identity)
+	}
+	switch c.Format {
+	case "", "json", "text", "csv":
+	default:
+		problem("format: unknown format %q, use json, text or csv", c.Format)
+	}
+
+	for _, expression := range append(append([]string{}, c.Filters.Include...), c.Filters.Exclude...) {
+		if err := checkFilter(expression); err != nil {
+			problem("filters: %v", err)
+		}
+	}
+
+	checkRule := func(where string, name string, rule healthRule) {
+		if _, ok := defaultRules[name]; !ok {
+			problem("%v: unknown rule %q", where, name)
+		}
+		if len(rule.Severity) > 0 && severityLevel(rule.Severity) == 0 && rule.Severity != "ok" {
+			problem("%v: unknown severity %q for rule %q", where, rule.Severity, name)
+		}
+	}
+	for name, rule := range c.Health.Rules {
+		checkRule("health rules", name, rule)
+	}
+	for device, rules := range c.Health.Overrides {
+		for name, rule := range rules {
+			checkRule("health overrides "+device, name, rule)
+		}
+	}
+
+	for _, address := range c.Webhooks.URLs {
+		if u, err := url.Parse(address); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problem("webhooks: %q is not an http or https URL", address)
+		}
+	}
+	programs := []string{c.Hooks.Program}
+	for event, program := range c.Hooks.Events {
+		if !knownHookEvent(event) {
+			problem("hooks: unknown event %q", event)
+		}
+		programs = append(programs, program)
+	}
+	for _, program := range programs {
+		if len(program) < 1 {
+			continue
+		}
+		if info, err := os.Stat(program); err != nil {
+			problem("hooks: %v", err)
+		} else if info.IsDir() || info.Mode()&0111 == 0 {
+			problem("hooks: %v is not executable", program)
+		}
+	}
+	if len(c.HTTP.TokenFile) > 0 {
+		if info, err := os.Stat(c.HTTP.TokenFile); err != nil {
+			problem("http: %v", err)
+		} else if info.Mode()&0077 != 0 {
+			problem("http: token file %v is accessible by other users", c.HTTP.TokenFile)
+		}
+	}
+	switch c.Log.Target {
+	case "", "journald", "syslog":
+	default:
+		problem("log: unknown target %q, use journald or syslog", c.Log.Target)
+	}
+	switch c.Log.Network {
+	case "", "unix", "unixgram", "udp", "tcp":
+	default:
+		problem("log: unknown network %q", c.Log.Network)
+	}
+	return problems
+}
+
+func configCommand(args []string, path string) {
+	if len(args) < 1 || (args[0] != "validate" && args[0] != "show") {
+		fmt.Println("[validate, show] - required one config command")
+		os.Exit(1)
+	}
+	if args[0] == "show" {
+		shown := cfg
+		if len(shown.HTTP.Token) > 0 {
+			shown.HTTP.Token = "********"
+		}
+		r, _ := json.MarshalIndent(shown, "", "  ")
+		fmt.Println(string(r))
+		return
+	}
+
+	problems := validateConfig(path)
+	if len(problems) < 1 {
+		fmt.Println("OK")
+		return
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	os.Exit(1)
+}
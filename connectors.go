@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type connectorInfo struct {
+	ConnectorID  string `json:"connector id"`
+	Controller   int    `json:"controller"`
+	Number       string `json:"connector number"`
+	Name         string `json:"connector name"`
+	Lanes        string `json:"number of lanes"`
+	Type         string `json:"connector type"`
+	Mode         string `json:"connector mode"`
+	Location     string `json:"connector location"`
+	Phys         int    `json:"phy count"`
+	DegradedPhys int    `json:"degraded phys"`
+}
+
+type phyInfo struct {
+	PhyID                      string `json:"phy id"`
+	Controller                 int    `json:"controller"`
+	Number                     string `json:"phy number"`
+	NegotiatedLinkRate         string `json:"negotiated link rate"`
+	MaximumLinkRate            string `json:"maximum link rate"`
+	AttachedDevice             string `json:"attached device"`
+	InvalidDwordCount          int    `json:"invalid dword count"`
+	RunningDisparityErrorCount int    `json:"running disparity error count"`
+	LossOfDwordSyncCount       int    `json:"loss of dword sync count"`
+	PhyResetProblemCount       int    `json:"phy reset problem count"`
+	Degraded                   string `json:"degraded"`
+}
+
+func getLinks() ([]connectorInfo, []phyInfo, error) {
+	if reply, err := askDaemon("links"); err == nil {
+		return reply.Connectors, reply.Phys, nil
+	}
+	return collectLinks()
+}
+
+func collectLinks() ([]connectorInfo, []phyInfo, error) {
+	controllers, err := controllersCount()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connectors := []connectorInfo{}
+	phys := []phyInfo{}
+	for controller := 1; controller <= controllers; controller++ {
+		out, err := getConfig(controller, "CN")
+		if err != nil {
+			return nil, nil, err
+		}
+		c, p := parseConnectors(controller, out)
+		connectors = append(connectors, c...)
+		phys = append(phys, p...)
+
+		out, err = getConfig(controller, "PD")
+		if err != nil {
+			return nil, nil, err
+		}
+		phys = append(phys, parseDevicePhys(controller, out)...)
+	}
+	return connectors, phys, nil
+}
+
+func parseConnectors(controller int, out string) ([]connectorInfo, []phyInfo) {
+	connectors := []connectorInfo{}
+	phys := []phyInfo{}
+	var connector *connectorInfo
+	var phy *phyInfo
+
+	flush := func() {
+		if phy != nil {
+			phy.linkState()
+			if phy.Degraded == "Yes" {
+				connector.DegradedPhys++
+			}
+			connector.Phys++
+			phys = append(phys, *phy)
+			phy = nil
+		}
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		header := strings.ToLower(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(header, "connector #"):
+			flush()
+			if connector != nil {
+				connectors = append(connectors, *connector)
+			}
+			number := strings.TrimSpace(header[len("connector #"):])
+			connector = &connectorInfo{
+				ConnectorID: "Controller " + strconv.Itoa(controller) + ", Connector " + number,
+				Controller:  controller,
+				Number:      number,
+			}
+		case connector != nil && strings.HasPrefix(header, "phy #"):
+			flush()
+			number := strings.TrimSpace(header[len("phy #"):])
+			phy = &phyInfo{
+				PhyID:      connector.ConnectorID + ", PHY " + number,
+				Controller: controller,
+				Number:     number,
+			}
+		case phy != nil:
+			phy.phyParserInfo(line)
+		case connector != nil:
+			connector.connectorParserInfo(line)
+		}
+	}
+	if connector != nil {
+		flush()
+		connectors = append(connectors, *connector)
+	}
+	return connectors, phys
+}
+
+// parseDevicePhys picks up the "Device Phy Information" blocks that newer
+// arcconf releases print inside every physical device section.
+func parseDevicePhys(controller int, out string) []phyInfo {
+	phys := []phyInfo{}
+	for _, pdinfo := range strings.Split(out, "Device #") {
+		device := pdInfo{}
+		var phy *phyInfo
+
+		for _, line := range strings.Split(pdinfo, "\n") {
+			header := strings.ToLower(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(header, "phy #"):
+				if phy != nil {
+					phys = append(phys, *phy)
+				}
+				number := strings.TrimSpace(header[len("phy #"):])
+				phy = &phyInfo{
+					PhyID:          device.DeviceID + ", PHY " + number,
+					Controller:     controller,
+					Number:         number,
+					AttachedDevice: device.DeviceID,
+				}
+			case phy != nil:
+				phy.phyParserInfo(line)
+			default:
+				device.pdParserInfo(profileLine(line), controller)
+			}
+		}
+		if phy != nil {
+			phys = append(phys, *phy)
+		}
+	}
+	for i := range phys {
+		phys[i].linkState()
+	}
+	return phys
+}
+
+func (p *phyInfo) linkState() {
+	p.Degraded = "No"
+	negotiated := parseSpeedGbps(p.NegotiatedLinkRate)
+	maximum := parseSpeedGbps(p.MaximumLinkRate)
+	if negotiated > 0 && negotiated < maximum {
+		p.Degraded = "Yes"
+	}
+}
+
+func cnDiscovery() {
+	connectors, _, err := getLinks()
+	if err != nil {
+		fmt.Printf("Cannot get arcconf connector information\n - %v", err)
+		os.Exit(1)
+	}
+
+	devices := []discoveryDevice{}
+	for _, connector := range connectors {
+		devices = append(devices, discoveryDevice{
+			DeviceID:    connector.ConnectorID,
+			DeviceType:  "CN",
+			DeviceAlias: connector.Name,
+			Present:     "Present",
+		})
+	}
+	data := data{Data: devices}
+
+	r, _ := json.Marshal(data)
+	fmt.Print(string(r))
+}
+
+func phyDiscovery() {
+	_, phys, err := getLinks()
+	if err != nil {
+		fmt.Printf("Cannot get arcconf connector information\n - %v", err)
+		os.Exit(1)
+	}
+
+	devices := []discoveryDevice{}
+	for _, phy := range phys {
+		devices = append(devices, discoveryDevice{
+			DeviceID:    phy.PhyID,
+			DeviceType:  "PHY",
+			DeviceAlias: phy.AttachedDevice,
+			Present:     phy.NegotiatedLinkRate,
+		})
+	}
+	data := data{Data: devices}
+
+	r, _ := json.Marshal(data)
+	fmt.Print(string(r))
+}
+
+func cnStats(connectorName string) {
+	connectors, _, err := getLinks()
+	if err != nil {
+		fmt.Printf("Cannot get arcconf connector information\n - %v", err)
+		os.Exit(1)
+	}
+
+	for _, connector := range connectors {
+		if connector.ConnectorID == connectorName {
+			printStats(connector)
+			return
+		}
+	}
+	notExist("CN", connectorName)
+}
+
+func phyStats(phyName string) {
+	_, phys, err := getLinks()
+	if err != nil {
+		fmt.Printf("Cannot get arcconf connector information\n - %v", err)
+		os.Exit(1)
+	}
+
+	for _, phy := range phys {
+		if phy.PhyID == phyName {
+			printStats(phy)
+			return
+		}
+	}
+	notExist("PHY", phyName)
+}
+
+func (c *connectorInfo) connectorParserInfo(line string) error {
+	split := strings.Split(line, " : ")
+	if len(split) < 2 {
+		return nil
+	}
+	match := strings.ToLower(strings.TrimSpace(split[0]))
+	switch match {
+	case "connector name":
+		c.Name = strings.TrimSpace(split[1])
+	case "number of lanes":
+		c.Lanes = strings.TrimSpace(split[1])
+	case "connector type":
+		c.Type = strings.TrimSpace(split[1])
+	case "connector mode":
+		c.Mode = strings.TrimSpace(split[1])
+	case "connector location":
+		c.Location = strings.TrimSpace(split[1])
+	}
+	return nil
+}
+
+func (p *phyInfo) phyParserInfo(line string) error {
+	split := strings.Split(line, " : ")
+	if len(split) < 2 {
+		return nil
+	}
+	match := strings.ToLower(strings.TrimSpace(split[0]))
+	value := strings.TrimSpace(split[1])
+	switch match {
+	case "negotiated link rate", "negotiated physical link rate":
+		p.NegotiatedLinkRate = value
+	case "maximum link rate", "maximum physical link rate":
+		p.MaximumLinkRate = value
+	case "attached device", "attached sas address", "sas address":
+		if len(p.AttachedDevice) < 1 {
+			p.AttachedDevice = value
+		}
+	case "invalid dword count":
+		p.InvalidDwordCount, _ = strconv.Atoi(value)
+	case "running disparity error count", "disparity error count":
+		p.RunningDisparityErrorCount, _ = strconv.Atoi(value)
+	case "loss of dword synchronization count", "loss of dword sync count":
+		p.LossOfDwordSyncCount, _ = strconv.Atoi(value)
+	case "phy reset problem count", "reset problem count":
+		p.PhyResetProblemCount, _ = strconv.Atoi(value)
+	}
+	return nil
+}
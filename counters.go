@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+const countersState = "counters.json"
+
+func parseCounter(value string) int {
+	count, _ := strconv.Atoi(strings.TrimSpace(value))
+	return count
+}
+
+// counterBaseline holds the counters of a drive as one reader last saw
+// them. Every reader (a -field, the whole object, the daemon) keeps its own
+// baseline, so each of them sees every increase once, no matter how many
+// other items poll the same drive in between.
+type counterBaseline struct {
+	Collected int64      `json:"collected"`
+	Counters  pdCounters `json:"counters"`
+}
+
+// trackCounters fills in the delta of every drive since reader last read it
+// and moves the baseline of that reader to the collection taken at collected.
+func trackCounters(pds []pdInfo, collected int64, reader string) error {
+	unlock, err := lockState(countersState)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	baselines := map[string]counterBaseline{}
+	if err := loadState(countersState, &baselines); err != nil {
+		return err
+	}
+
+	for i, pd := range pds {
+		key := pd.SerialNumber
+		if len(key) < 1 {
+			key = pd.DeviceID
+		}
+		key += "|" + reader
+		baseline, ok := baselines[key]
+		if ok && collected < baseline.Collected {
+			// An older collection, e.g. from the cache, was already counted.
+			continue
+		}
+		if ok {
+			pds[i].Delta = pd.pdCounters.since(baseline.Counters)
+		}
+		baselines[key] = counterBaseline{Collected: collected, Counters: pd.pdCounters}
+	}
+	return saveState(countersState, baselines)
+}
+
+func (c pdCounters) since(last pdCounters) pdCounters {
+	delta := func(now, before int) int {
+		// A lower value means the counters were reset or the drive changed.
+		if now < before {
+			return now
+		}
+		return now - before
+	}
+	return pdCounters{
+		HardwareErrors:  delta(c.HardwareErrors, last.HardwareErrors),
+		MediumErrors:    delta(c.MediumErrors, last.MediumErrors),
+		ParityErrors:    delta(c.ParityErrors, last.ParityErrors),
+		LinkFailures:    delta(c.LinkFailures, last.LinkFailures),
+		AbortedCommands: delta(c.AbortedCommands, last.AbortedCommands),
+		SmartWarnings:   delta(c.SmartWarnings, last.SmartWarnings),
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var daemonSocket = "/run/zabbix-adaptec.sock"
+
+// staleAfter is the number of poll intervals after which the last good
+// collection is no longer served, e.g. when arcconf hangs.
+const staleAfter = 3
+
+type collector struct {
+	mu       sync.RWMutex
+	interval time.Duration
+	inv      *inventory
+	err      error
+	// parts holds the last reply to the requests other than "inventory".
+	parts map[string]daemonReply
+	// queued wakes up the outbox delivery after a poll.
+	queued chan struct{}
+}
+
+type daemonReply struct {
+	Inventory  *inventory              `json:"inventory,omitempty"`
+	Tasks      []taskInfo              `json:"tasks,omitempty"`
+	Connectors []connectorInfo         `json:"connectors,omitempty"`
+	Phys       []phyInfo               `json:"phys,omitempty"`
+	Logs       map[string][][]logEntry `json:"logs,omitempty"`
+	Collected  int64                   `json:"collected,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+func daemon(socket string, interval time.Duration, listen string, tokenFile string) {
+	token, err := cfg.HTTP.token(tokenFile)
+	if err != nil {
+		fmt.Printf("Cannot read the HTTP API token\n - %v", err)
+		os.Exit(1)
+	}
+
+	c := &collector{interval: interval, parts: map[string]daemonReply{}, queued: make(chan struct{}, 1)}
+	c.poll()
+
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		fmt.Printf("Cannot listen on %v\n - %v", socket, err)
+		os.Exit(1)
+	}
+	// The zabbix agent runs as its own user and must be able to connect.
+	os.Chmod(socket, 0666)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		listener.Close()
+		os.Remove(socket)
+		os.Exit(0)
+	}()
+
+	if len(listen) > 0 {
+		go func() {
+			if err := http.ListenAndServe(listen, httpAPI{collector: c, token: token}); err != nil {
+				log.Printf("http: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			c.poll()
+		}
+	}()
+
+	// Webhooks and hooks are delivered apart from the polls, a slow
+	// receiver or program must not delay a collection.
+	go func() {
+		retry := time.NewTicker(webhookRetry * time.Second)
+		for {
+			select {
+			case <-retry.C:
+			case <-c.queued:
+			}
+			if err := deliverOutbox(time.Now().Unix()); err != nil {
+				log.Printf("notify: %v", err)
+			}
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("accept: %v", err)
+			continue
+		}
+		go c.serve(conn)
+	}
+}
+
+func (c *collector) poll() {
+	inv, err := collectInventory()
+	if err == nil {
+		observe(inv)
+		select {
+		case c.queued <- struct{}{}:
+		default:
+		}
+	} else {
+		log.Printf("collect: %v", err)
+	}
+
+	c.mu.Lock()
+	c.err = err
+	if err == nil {
+		c.inv = &inv
+	}
+	c.mu.Unlock()
+
+	tasks, err := collectTasks()
+	c.update("tasks", daemonReply{Tasks: tasks}, err)
+	connectors, phys, err := collectLinks()
+	c.update("links", daemonReply{Connectors: connectors, Phys: phys}, err)
+	logs := map[string][][]logEntry{}
+	for _, logType := range []string{"device", "dead", "event"} {
+		if logs[logType], err = collectLogs(logType); err != nil {
+			break
+		}
+	}
+	c.update("logs", daemonReply{Logs: logs}, err)
+}
+
+// update keeps the reply of the latest poll. A failed poll replaces the
+// earlier data, clients must see the failure instead of an old state.
+func (c *collector) update(request string, reply daemonReply, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.Printf("%v: %v", request, err)
+		reply = daemonReply{Error: err.Error()}
+	}
+	reply.Collected = time.Now().Unix()
+	c.parts[request] = reply
+}
+
+// current returns the inventory of the latest poll, or why there is none to
+// serve: the poll failed or it is too old to be trusted.
+func (c *collector) current() (*inventory, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch {
+	case c.err != nil:
+		return nil, c.err
+	case c.inv == nil:
+		return nil, fmt.Errorf("no collection yet")
+	}
+	return c.inv, c.fresh(c.inv.Collected)
+}
+
+func (c *collector) fresh(collected int64) error {
+	if age := time.Since(time.Unix(collected, 0)); age > staleAfter*c.interval {
+		return fmt.Errorf("last collection is %v old", age.Round(time.Second))
+	}
+	return nil
+}
+
+// observe runs everything that compares one collection with the previous
+// one. The daemon calls it on every poll so short-lived states are seen.
+func observe(inv inventory) {
+	now := time.Now().Unix()
+	if err := trackCounters(inv.PhysicalDevices, inv.Collected, "daemon"); err != nil {
+		log.Printf("error counters: %v", err)
+	}
+	changes, err := updateTransitions(inv, now)
+	if err != nil {
+		log.Printf("transitions: %v", err)
+	}
+	if err := notify(inv, changes); err != nil {
+		log.Printf("notify: %v", err)
+	}
+	if err := logHealth(evaluateHealth(inv)); err != nil {
+		log.Printf("log health: %v", err)
+	}
+	if _, err := updateLedger(inv, now); err != nil {
+		log.Printf("ledger: %v", err)
+	}
+}
+
+func (c *collector) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	request, _ := bufio.NewReader(conn).ReadString('\n')
+	reply := daemonReply{}
+	switch strings.TrimSpace(request) {
+	case "inventory":
+		inv, err := c.current()
+		if err != nil {
+			reply.Error = err.Error()
+			break
+		}
+		reply.Inventory = inv
+	case "tasks", "links", "logs":
+		c.mu.RLock()
+		part, ok := c.parts[strings.TrimSpace(request)]
+		c.mu.RUnlock()
+		switch {
+		case !ok:
+			reply.Error = "no collection yet"
+		case len(part.Error) > 0:
+			reply.Error = part.Error
+		default:
+			reply = part
+			if err := c.fresh(part.Collected); err != nil {
+				reply = daemonReply{Error: err.Error()}
+			}
+		}
+	default:
+		reply.Error = "unknown request"
+	}
+	json.NewEncoder(conn).Encode(reply)
+}
+
+// askDaemon sends one request to a running daemon. Without a daemon or
+// when it has nothing to serve the caller collects itself.
+func askDaemon(request string) (daemonReply, error) {
+	reply := daemonReply{}
+	conn, err := net.DialTimeout("unix", daemonSocket, time.Second)
+	if err != nil {
+		return reply, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := fmt.Fprintln(conn, request); err != nil {
+		return reply, err
+	}
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return reply, err
+	}
+	if len(reply.Error) > 0 {
+		return reply, fmt.Errorf("daemon: %v", reply.Error)
+	}
+	return reply, nil
+}
+
+func queryDaemon() (inventory, error) {
+	reply, err := askDaemon("inventory")
+	if err != nil {
+		return inventory{}, err
+	}
+	if reply.Inventory == nil {
+		return inventory{}, fmt.Errorf("daemon: no inventory")
+	}
+	reply.Inventory.fromDaemon = true
+	return *reply.Inventory, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	journalSocket  = "/run/systemd/journal/socket"
+	syslogSocket   = "/dev/log"
+	syslogAppName  = "zabbix-adaptec"
+	syslogFacility = 3 // daemon
+	// 32473 is the private enterprise number reserved for examples, as there
+	// is no registered one for this tool.
+	syslogSDID = "adaptec@32473"
+
+	problemsState = "problems.json"
+)
+
+type logConfig struct {
+	// Target is "journald", "syslog" or empty to write no records.
+	Target  string `json:"target"`
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+type logRecord struct {
+	Priority int
+	Message  string
+	Fields   []logField
+}
+
+type logField struct {
+	Name  string
+	Value string
+}
+
+var severityPriority = map[string]int{"ok": 6, "info": 6, "warning": 4, "average": 4, "high": 3, "disaster": 2}
+
+func transitionRecord(inv inventory, t transition) logRecord {
+	e := webhookEvent(inv, t)
+	record := logRecord{
+		Priority: 5,
+		Message:  fmt.Sprintf("%v %v %v: %v -> %v", t.DeviceType, t.Device, t.Field, t.Old, t.New),
+		Fields: []logField{
+			{"EVENT", "transition"},
+			{"CONTROLLER", strconv.Itoa(e.Controller.Number)},
+			{"DEVICE_TYPE", t.DeviceType},
+			{"DEVICE", t.Device},
+			{"FIELD", t.Field},
+			{"OLD_STATE", t.Old},
+			{"STATE", t.New},
+		},
+	}
+	if e.Identity != nil {
+		record.Fields = append(record.Fields, logField{"SERIAL", e.Identity.SerialNumber}, logField{"LOCATION", e.Identity.Location})
+	}
+	switch hookEvent(t) {
+	case "drive-failed", "ld-degraded", "battery-failed", "temperature-critical":
+		record.Priority = 3
+	}
+	return record
+}
+
+func problemRecord(p healthProblem) logRecord {
+	record := logRecord{
+		Priority: severityPriority[p.Severity],
+		Message:  p.Message,
+		Fields: []logField{
+			{"EVENT", "health"},
+			{"RULE", p.Rule},
+			{"SEVERITY", p.Severity},
+			{"CONTROLLER", strconv.Itoa(p.Controller)},
+			{"DEVICE_TYPE", p.DeviceType},
+			{"DEVICE", p.Device},
+			{"MAINTENANCE", strconv.FormatBool(p.Maintenance)},
+		},
+	}
+	if len(p.SerialNumber) > 0 {
+		record.Fields = append(record.Fields, logField{"SERIAL", p.SerialNumber})
+	}
+	return record
+}
+
+func logTransitions(inv inventory, changes []transition) error {
+	records := []logRecord{}
+	for _, t := range changes {
+		records = append(records, transitionRecord(inv, t))
+	}
+	return writeRecords(records)
+}
+
+// clearedRecord reports that a problem logged earlier is gone.
+func clearedRecord(p healthProblem) logRecord {
+	record := problemRecord(p)
+	record.Priority = severityPriority["ok"]
+	record.Message = "resolved: " + p.Message
+	record.Fields[2] = logField{"SEVERITY", "ok"}
+	return record
+}
+
+// logHealth writes a record when a problem appears, changes severity or
+// clears. The problems already logged are kept in the state directory, so
+// an unchanged problem is not repeated on every poll.
+func logHealth(result healthResult) error {
+	if len(cfg.Log.Target) < 1 {
+		return nil
+	}
+	unlock, err := lockState(problemsState)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	logged := map[string]healthProblem{}
+	if err := loadState(problemsState, &logged); err != nil {
+		return err
+	}
+
+	records := []logRecord{}
+	current := map[string]healthProblem{}
+	for _, p := range result.Problems {
+		key := p.Rule + "|" + p.DeviceType + "|" + p.Device
+		current[key] = p
+		if last, ok := logged[key]; !ok || last.Severity != p.Severity {
+			records = append(records, problemRecord(p))
+		}
+	}
+	keys := []string{}
+	for key := range logged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := current[key]; !ok {
+			records = append(records, clearedRecord(logged[key]))
+		}
+	}
+
+	// Without the records written the state stays, the next run tries again.
+	if err := writeRecords(records); err != nil {
+		return err
+	}
+	return saveState(problemsState, current)
+}
+
+func writeRecords(records []logRecord) error {
+	if len(records) < 1 {
+		return nil
+	}
+	switch cfg.Log.Target {
+	case "journald":
+		return writeJournal(records)
+	case "syslog":
+		return writeSyslog(records)
+	}
+	return nil
+}
+
+// writeJournal sends every record as one datagram in the journald native
+// protocol. Values with a newline use the length prefixed binary form.
+func writeJournal(records []logRecord) error {
+	address := cfg.Log.Address
+	if len(address) < 1 {
+		address = journalSocket
+	}
+	conn, err := net.Dial("unixgram", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, record := range records {
+		var b bytes.Buffer
+		field := func(name string, value string) {
+			if !strings.Contains(value, "\n") {
+				fmt.Fprintf(&b, "%v=%v\n", name, value)
+				return
+			}
+			b.WriteString(name + "\n")
+			binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+			b.WriteString(value + "\n")
+		}
+		field("MESSAGE", record.Message)
+		field("PRIORITY", strconv.Itoa(record.Priority))
+		field("SYSLOG_IDENTIFIER", syslogAppName)
+		for _, f := range record.Fields {
+			field("ADAPTEC_"+f.Name, f.Value)
+		}
+		if _, err := conn.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSyslog sends RFC 5424 messages with the fields as structured data.
+// Stream transports use octet counting framing from RFC 6587.
+func writeSyslog(records []logRecord) error {
+	network, address := cfg.Log.Network, cfg.Log.Address
+	if len(network) < 1 {
+		network = "unixgram"
+	}
+	if len(address) < 1 {
+		address = syslogSocket
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	host, _ := os.Hostname()
+	if len(host) < 1 {
+		host = "-"
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	for _, record := range records {
+		params := []string{}
+		for _, f := range record.Fields {
+			params = append(params, fmt.Sprintf(`%v="%v"`, strings.ToLower(f.Name), escape.Replace(f.Value)))
+		}
+		msgID := "-"
+		if len(record.Fields) > 0 && record.Fields[0].Name == "EVENT" {
+			msgID = record.Fields[0].Value
+		}
+		message := fmt.Sprintf("<%v>1 %v %v %v %v %v [%v %v] %v",
+			syslogFacility*8+record.Priority,
+			time.Now().Format(time.RFC3339),
+			host, syslogAppName, os.Getpid(), msgID,
+			syslogSDID, strings.Join(params, " "), record.Message)
+		if network == "tcp" || network == "unix" {
+			message = fmt.Sprintf("%v %v", len(message), message)
+		}
+		if _, err := conn.Write([]byte(message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type hardwareInventory struct {
+	Host            string            `json:"host"`
+	Collected       string            `json:"collected"`
+	Controllers     []controllerAsset `json:"controllers"`
+	LogicalDevices  []logicalAsset    `json:"logical devices"`
+	PhysicalDevices []physicalAsset   `json:"physical devices"`
+}
+
+type controllerAsset struct {
+	Controller   int    `json:"controller"`
+	Model        string `json:"model"`
+	SerialNumber string `json:"serial number"`
+	WWN          string `json:"world wide name"`
+	BIOS         string `json:"bios"`
+	Firmware     string `json:"firmware"`
+	Driver       string `json:"driver"`
+	Memory       string `json:"installed memory"`
+}
+
+type logicalAsset struct {
+	Controller         int    `json:"controller"`
+	Number             string `json:"logical device number"`
+	Name               string `json:"logical device name"`
+	UniqueIdentifier   string `json:"unique identifier"`
+	RaidLevel          string `json:"raid level"`
+	Size               string `json:"size"`
+	StripeUnitSize     string `json:"stripe-unit size"`
+	ReadCacheSettings  string `json:"read-cache setting"`
+	WriteCacheSettings string `json:"write-cache setting"`
+}
+
+type physicalAsset struct {
+	Controller   int    `json:"controller"`
+	Location     string `json:"location"`
+	Vendor       string `json:"vendor"`
+	Model        string `json:"model"`
+	SerialNumber string `json:"serial number"`
+	WWN          string `json:"world-wide name"`
+	Firmware     string `json:"firmware"`
+	TotalSize    string `json:"total size"`
+	SSD          string `json:"ssd"`
+}
+
+var inventoryColumns = []string{
+	"host", "collected", "type", "controller", "id", "name", "vendor", "model", "serial number", "wwn",
+	"firmware", "bios", "driver", "memory", "raid level", "size", "stripe-unit size", "read cache", "write cache", "ssd",
+}
+
+func hardware(inv inventory) hardwareInventory {
+	host, _ := os.Hostname()
+	hw := hardwareInventory{
+		Host:            host,
+		Collected:       time.Unix(inv.Collected, 0).UTC().Format(time.RFC3339),
+		Controllers:     []controllerAsset{},
+		LogicalDevices:  []logicalAsset{},
+		PhysicalDevices: []physicalAsset{},
+	}
+	for _, ad := range inv.Controllers {
+		hw.Controllers = append(hw.Controllers, controllerAsset{
+			Controller:   ad.Controller,
+			Model:        ad.ControllerModel,
+			SerialNumber: ad.ControllerSerialNumber,
+			WWN:          ad.ControllerWorldWideName,
+			BIOS:         ad.BIOS,
+			Firmware:     ad.Firmware,
+			Driver:       ad.Driver,
+			Memory:       ad.InstalledMemory,
+		})
+	}
+	for _, ld := range inv.LogicalDevices {
+		hw.LogicalDevices = append(hw.LogicalDevices, logicalAsset{
+			Controller:         ld.Controller,
+			Number:             ld.Number,
+			Name:               ld.LdName,
+			UniqueIdentifier:   ld.UniqueIdentifier,
+			RaidLevel:          ld.RaidLevel,
+			Size:               ld.Size,
+			StripeUnitSize:     ld.StripeUnitSize,
+			ReadCacheSettings:  ld.ReadCacheSettings,
+			WriteCacheSettings: ld.WriteCacheSettings,
+		})
+	}
+	for _, pd := range inv.PhysicalDevices {
+		hw.PhysicalDevices = append(hw.PhysicalDevices, physicalAsset{
+			Controller:   pd.Controller,
+			Location:     pd.DeviceID,
+			Vendor:       pd.Vendor,
+			Model:        pd.Model,
+			SerialNumber: pd.SerialNumber,
+			WWN:          pd.WWN,
+			Firmware:     pd.Firmware,
+			TotalSize:    pd.TotalSize,
+			SSD:          pd.SSD,
+		})
+	}
+	return hw
+}
+
+// inventoryRows flattens the inventory into one CSV row per device, the
+// "type" column tells controllers, logical and physical devices apart.
+func inventoryRows(hw hardwareInventory) [][]string {
+	rows := [][]string{inventoryColumns}
+	row := func(values map[string]string) {
+		line := []string{}
+		for _, column := range inventoryColumns {
+			line = append(line, values[column])
+		}
+		rows = append(rows, line)
+	}
+	for _, ad := range hw.Controllers {
+		row(map[string]string{
+			"type":          "controller",
+			"controller":    strconv.Itoa(ad.Controller),
+			"id":            strconv.Itoa(ad.Controller),
+			"model":         ad.Model,
+			"serial number": ad.SerialNumber,
+			"wwn":           ad.WWN,
+			"firmware":      ad.Firmware,
+			"bios":          ad.BIOS,
+			"driver":        ad.Driver,
+			"memory":        ad.Memory,
+		})
+	}
+	for _, ld := range hw.LogicalDevices {
+		row(map[string]string{
+			"type":             "logical device",
+			"controller":       strconv.Itoa(ld.Controller),
+			"id":               ld.UniqueIdentifier,
+			"name":             ld.Name,
+			"raid level":       ld.RaidLevel,
+			"size":             ld.Size,
+			"stripe-unit size": ld.StripeUnitSize,
+			"read cache":       ld.ReadCacheSettings,
+			"write cache":      ld.WriteCacheSettings,
+		})
+	}
+	for _, pd := range hw.PhysicalDevices {
+		row(map[string]string{
+			"type":          "physical device",
+			"controller":    strconv.Itoa(pd.Controller),
+			"id":            pd.Location,
+			"vendor":        pd.Vendor,
+			"model":         pd.Model,
+			"serial number": pd.SerialNumber,
+			"wwn":           pd.WWN,
+			"firmware":      pd.Firmware,
+			"size":          pd.TotalSize,
+			"ssd":           pd.SSD,
+		})
+	}
+	for _, line := range rows[1:] {
+		line[0], line[1] = hw.Host, hw.Collected
+	}
+	return rows
+}
+
+// inventoryReport exports what is installed right now, it always collects
+// every device itself.
+func inventoryReport(format string) {
+	inv, err := collectInventory()
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+
+	hw := hardware(inv)
+	if format == "csv" {
+		w := csv.NewWriter(os.Stdout)
+		w.WriteAll(inventoryRows(hw))
+		return
+	}
+	r, _ := json.Marshal(hw)
+	fmt.Print(string(r))
+}
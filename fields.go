@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// captureExtra keeps keys the parsers do not know in the "extra" map of the
+// stats output instead of dropping them.
+var captureExtra = true
+
+type fieldSet struct {
+	Recognized   []string `json:"recognized"`
+	Unrecognized []string `json:"unrecognized"`
+}
+
+// extraKeys names keys that only one backend reports, or that the backends
+// name differently, so "extra" is the same whichever backend ran. An empty
+// name drops the key.
+var extraKeys = map[string]string{
+	"reported channel,device(t:l)": "", // text only, same as "reported location"
+	"logical device id":            "", // JSON only, the logical device number
+	"phy id":                       "", // JSON only, text has a "Phy #0" header
+}
+
+func extraField(extra map[string]string, match string, split []string) map[string]string {
+	if name, ok := extraKeys[match]; ok {
+		match = name
+	}
+	if !captureExtra || len(split) < 2 || len(match) < 1 {
+		return extra
+	}
+	if extra == nil {
+		extra = map[string]string{}
+	}
+	extra[match] = strings.TrimSpace(strings.Join(split[1:], " : "))
+	return extra
+}
+
+func fieldsReport() {
+	controllers, err := controllersCount()
+	if err != nil {
+		fmt.Printf("Cannot check lspci adaptec controllers\n - %v", err)
+		os.Exit(1)
+	}
+
+	seen := map[string]map[string]bool{"ad": {}, "ld": {}, "pd": {}}
+	for controller := 1; controller <= controllers; controller++ {
+		for deviceType, known := range seen {
+			lines, err := fieldLines(controller, deviceType)
+			if err != nil {
+				fmt.Printf("Error %v", err)
+				os.Exit(1)
+			}
+			for _, line := range lines {
+				split := strings.Split(line, " : ")
+				if len(split) < 2 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(split[0]))
+				if len(key) < 1 {
+					continue
+				}
+				known[key] = known[key] || recognized(deviceType, line)
+			}
+		}
+	}
+
+	report := map[string]fieldSet{}
+	for deviceType, keys := range seen {
+		set := fieldSet{Recognized: []string{}, Unrecognized: []string{}}
+		for key, ok := range keys {
+			if ok {
+				set.Recognized = append(set.Recognized, key)
+			} else {
+				set.Unrecognized = append(set.Unrecognized, key)
+			}
+		}
+		sort.Strings(set.Recognized)
+		sort.Strings(set.Unrecognized)
+		report[deviceType] = set
+	}
+
+	r, _ := json.Marshal(report)
+	fmt.Print(string(r))
+}
+
+// fieldLines returns the "key : value" lines the active backend feeds to the
+// parsers for one controller and device type.
+func fieldLines(controller int, deviceType string) ([]string, error) {
+	if useJSON() {
+		if doc, err := getConfigJSON(controller, strings.ToUpper(deviceType)); err == nil {
+			switch deviceType {
+			case "ad":
+				return jsonLines(doc, adKeys, isDeviceList), nil
+			case "ld":
+				lines := []string{}
+				for _, device := range jsonList(doc, isLogicalList) {
+					lines = append(lines, jsonLines(device, ldKeys, isMemberList)...)
+				}
+				return lines, nil
+			case "pd":
+				lines := []string{}
+				for _, device := range jsonList(doc, isPhysicalList) {
+					lines = append(lines, jsonLines(device, pdKeys, nil)...)
+				}
+				return lines, nil
+			}
+		}
+	}
+
+	out, err := getConfig(controller, strings.ToUpper(deviceType))
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		lines = append(lines, profileLine(line))
+	}
+	return lines, nil
+}
+
+func recognized(deviceType string, line string) bool {
+	switch deviceType {
+	case "ad":
+		ad := adInfo{}
+		ad.adParserInfo(line)
+		return len(ad.Extra) < 1
+	case "ld":
+		ld := ldInfo{}
+		ld.ldParserInfo(line)
+		return len(ld.Extra) < 1
+	case "pd":
+		pd := pdInfo{}
+		pd.pdParserInfo(line, 0)
+		return len(pd.Extra) < 1
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A filter expression is a comma separated list of key=pattern terms that
+// must all match, for example "type=pd,state=Raw*". Patterns are shell
+// globs compared case-insensitively.
+var filterKeys = []string{"controller", "type", "model", "serial", "state", "name", "raid", "location"}
+
+type filterConfig struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// filterList collects a repeatable -include or -exclude flag.
+type filterList []string
+
+func (f *filterList) String() string {
+	return strings.Join(*f, "; ")
+}
+
+func (f *filterList) Set(value string) error {
+	if err := checkFilter(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+func checkFilter(expression string) error {
+	for _, term := range strings.Split(expression, ",") {
+		split := strings.SplitN(term, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("filter term %q is not key=pattern", term)
+		}
+		key := strings.ToLower(strings.TrimSpace(split[0]))
+		known := false
+		for _, name := range filterKeys {
+			known = known || name == key
+		}
+		if !known {
+			return fmt.Errorf("unknown filter key %q, use one of %v", key, strings.Join(filterKeys, ", "))
+		}
+		if _, err := path.Match(split[1], ""); err != nil {
+			return fmt.Errorf("filter term %q: %v", term, err)
+		}
+	}
+	return nil
+}
+
+// setFilters replaces the configured filters with the ones given on the
+// command line.
+func setFilters(include filterList, exclude filterList) {
+	if len(include) > 0 {
+		cfg.Filters.Include = include
+	}
+	if len(exclude) > 0 {
+		cfg.Filters.Exclude = exclude
+	}
+}
+
+func filterMatch(expression string, fields map[string]string) bool {
+	for _, term := range strings.Split(expression, ",") {
+		split := strings.SplitN(term, "=", 2)
+		if len(split) != 2 {
+			return false
+		}
+		value, ok := fields[strings.ToLower(strings.TrimSpace(split[0]))]
+		if !ok {
+			return false
+		}
+		pattern := strings.ToLower(strings.TrimSpace(split[1]))
+		if matched, _ := path.Match(pattern, strings.ToLower(value)); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (f filterConfig) excluded(fields map[string]string) bool {
+	for _, expression := range f.Exclude {
+		if filterMatch(expression, fields) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterApplies tells whether an expression is about this kind of device:
+// its type term matches and the device has every key it names. An include
+// like "type=pd,state=Online" leaves the controllers and LDs alone.
+func filterApplies(expression string, fields map[string]string) bool {
+	for _, term := range strings.Split(expression, ",") {
+		split := strings.SplitN(term, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(split[0]))
+		value, ok := fields[key]
+		if !ok {
+			return false
+		}
+		if key == "type" && len(split) == 2 {
+			if matched, _ := path.Match(strings.ToLower(strings.TrimSpace(split[1])), value); !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f filterConfig) keep(fields map[string]string) bool {
+	if f.excluded(fields) {
+		return false
+	}
+	applied := false
+	for _, expression := range f.Include {
+		if !filterApplies(expression, fields) {
+			continue
+		}
+		applied = true
+		if filterMatch(expression, fields) {
+			return true
+		}
+	}
+	return !applied
+}
+
+// filterInventory drops the filtered devices. The logical and physical
+// devices of an excluded controller go with it.
+func filterInventory(inv inventory) inventory {
+	f := cfg.Filters
+	if len(f.Include) < 1 && len(f.Exclude) < 1 {
+		return inv
+	}
+
+	dropped := map[int]bool{}
+	controllers := []adInfo{}
+	for _, ad := range inv.Controllers {
+		fields := map[string]string{
+			"controller": strconv.Itoa(ad.Controller),
+			"type":       "ad",
+			"model":      ad.ControllerModel,
+			"serial":     ad.ControllerSerialNumber,
+			"state":      ad.ControllerStatus,
+		}
+		if f.excluded(fields) {
+			dropped[ad.Controller] = true
+		}
+		if f.keep(fields) {
+			controllers = append(controllers, ad)
+		}
+	}
+
+	lds := []ldInfo{}
+	for _, ld := range inv.LogicalDevices {
+		if dropped[ld.Controller] || !f.keep(map[string]string{
+			"controller": strconv.Itoa(ld.Controller),
+			"type":       "ld",
+			"state":      ld.StatusLD,
+			"name":       ld.LdName,
+			"raid":       ld.RaidLevel,
+		}) {
+			continue
+		}
+		lds = append(lds, ld)
+	}
+
+	pds := []pdInfo{}
+	for _, pd := range inv.PhysicalDevices {
+		if dropped[pd.Controller] || !f.keep(map[string]string{
+			"controller": strconv.Itoa(pd.Controller),
+			"type":       "pd",
+			"model":      pd.Model,
+			"serial":     pd.SerialNumber,
+			"state":      pd.State,
+			"location":   pd.DeviceID,
+		}) {
+			continue
+		}
+		pds = append(pds, pd)
+	}
+
+	inv.Controllers, inv.LogicalDevices, inv.PhysicalDevices = controllers, lds, pds
+	return inv
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var severities = []string{"ok", "info", "warning", "average", "high", "disaster"}
+
+type healthConfig struct {
+	Rules     map[string]healthRule            `json:"rules"`
+	Overrides map[string]map[string]healthRule `json:"overrides"`
+}
+
+type healthRule struct {
+	Enabled   *bool    `json:"enabled,omitempty"`
+	Severity  string   `json:"severity,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+}
+
+type healthProblem struct {
+	Rule         string `json:"rule"`
+	Severity     string `json:"severity"`
+	Controller   int    `json:"controller"`
+	DeviceType   string `json:"device type"`
+	Device       string `json:"device"`
+	SerialNumber string `json:"serial number,omitempty"`
+	Message      string `json:"message"`
+	Maintenance  bool   `json:"maintenance"`
+}
+
+type healthResult struct {
+	Severity string          `json:"severity"`
+	Problems []healthProblem `json:"problems"`
+}
+
+var defaultRules = map[string]healthRule{
+	"controller-status":   {Severity: "high"},
+	"ld-status":           {Severity: "high"},
+	"pd-failed":           {Severity: "high"},
+	"smart-warnings":      {Severity: "warning", Threshold: threshold(0)},
+	"temperature":         {Severity: "warning", Threshold: threshold(90)},
+	"write-cache-battery": {Severity: "average"},
+	"hot-spare":           {Severity: "info"},
+}
+
+func threshold(value float64) *float64 {
+	return &value
+}
+
+// rule merges the built-in rule with the configuration file and the
+// overrides for any of the given device identifiers.
+func (h healthConfig) rule(name string, devices ...string) healthRule {
+	rule := defaultRules[name]
+	merge := func(from healthRule) {
+		if from.Enabled != nil {
+			rule.Enabled = from.Enabled
+		}
+		if len(from.Severity) > 0 {
+			rule.Severity = from.Severity
+		}
+		if from.Threshold != nil {
+			rule.Threshold = from.Threshold
+		}
+	}
+	if configured, ok := h.Rules[name]; ok {
+		merge(configured)
+	}
+	for _, device := range devices {
+		if override, ok := h.Overrides[device][name]; ok {
+			merge(override)
+		}
+	}
+	return rule
+}
+
+func (r healthRule) enabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+func (r healthRule) limit() float64 {
+	if r.Threshold == nil {
+		return 0
+	}
+	return *r.Threshold
+}
+
+func evaluateHealth(inv inventory) healthResult {
+	result := healthResult{Severity: "ok", Problems: []healthProblem{}}
+	suppressed := activeMaintenance()
+	report := func(name string, rule healthRule, controller int, deviceType string, device string, ids []string, message string) {
+		problem := healthProblem{
+			Rule:       name,
+			Severity:   rule.Severity,
+			Controller: controller,
+			DeviceType: deviceType,
+			Device:     device,
+			Message:    message,
+		}
+		if deviceType == "PD" {
+			problem.SerialNumber = ids[0]
+		}
+		// Planned work still shows up, but no longer raises the overall severity.
+		if underMaintenance(suppressed, deviceType, ids...) {
+			problem.Maintenance = true
+			problem.Severity = "info"
+		}
+		result.Problems = append(result.Problems, problem)
+		if severityLevel(problem.Severity) > severityLevel(result.Severity) {
+			result.Severity = problem.Severity
+		}
+	}
+
+	batteries := map[int]bool{}
+	for _, ad := range inv.Controllers {
+		id := strconv.Itoa(ad.Controller)
+		batteries[ad.Controller] = ad.BatteryPresent == "True" && strings.Contains(strings.ToLower(ad.Status), "optimal")
+
+		if rule := cfg.Health.rule("controller-status", id); rule.enabled() && ad.ControllerStatus != "Optimal" {
+			report("controller-status", rule, ad.Controller, "AD", id, []string{id}, fmt.Sprintf("controller %v status is %v", id, ad.ControllerStatus))
+		}
+		rule := cfg.Health.rule("temperature", id)
+		if temperature := parseTemperature(ad.Temperature); rule.enabled() && temperature > rule.limit() {
+			report("temperature", rule, ad.Controller, "AD", id, []string{id}, fmt.Sprintf("controller %v temperature %v C is above %v C", id, temperature, rule.limit()))
+		}
+	}
+
+	for _, ld := range inv.LogicalDevices {
+		name := ld.UniqueIdentifier
+		if rule := cfg.Health.rule("ld-status", name, ld.LdName); rule.enabled() && ld.StatusLD != "Optimal" {
+			report("ld-status", rule, ld.Controller, "LD", name, []string{name, ld.LdName}, fmt.Sprintf("logical device %v (%v) status is %v", ld.LdName, name, ld.StatusLD))
+		}
+		rule := cfg.Health.rule("write-cache-battery", name, ld.LdName)
+		if rule.enabled() && writeCacheOn(ld.WriteCacheStatus) && !batteries[ld.Controller] {
+			report("write-cache-battery", rule, ld.Controller, "LD", name, []string{name, ld.LdName}, fmt.Sprintf("logical device %v (%v) has write cache on without a healthy battery", ld.LdName, name))
+		}
+		rule = cfg.Health.rule("hot-spare", name, ld.LdName)
+		if rule.enabled() && redundantRaid(ld.RaidLevel) && ld.ProtectedByHotSpare != "Yes" {
+			report("hot-spare", rule, ld.Controller, "LD", name, []string{name, ld.LdName}, fmt.Sprintf("logical device %v (%v) is not protected by a hot spare", ld.LdName, name))
+		}
+	}
+
+	for _, pd := range inv.PhysicalDevices {
+		device := pd.identity(pdIdentityScheme)
+		ids := []string{pd.SerialNumber, pd.WWN, pd.DeviceID}
+		if rule := cfg.Health.rule("pd-failed", ids...); rule.enabled() && strings.Contains(strings.ToLower(pd.State), "fail") {
+			report("pd-failed", rule, pd.Controller, "PD", device, ids, fmt.Sprintf("physical device %v state is %v", device, pd.State))
+		}
+		rule := cfg.Health.rule("smart-warnings", ids...)
+		if rule.enabled() && float64(pd.SmartWarnings) > rule.limit() {
+			report("smart-warnings", rule, pd.Controller, "PD", device, ids, fmt.Sprintf("physical device %v has %v S.M.A.R.T. warnings", device, pd.SmartWarnings))
+		}
+	}
+	return result
+}
+
+func severityLevel(severity string) int {
+	for i, name := range severities {
+		if name == severity {
+			return i
+		}
+	}
+	return 0
+}
+
+// parseTemperature reads the Celsius value out of "54 C/ 129 F (Normal)".
+func parseTemperature(temperature string) float64 {
+	fields := strings.Fields(temperature)
+	if len(fields) < 1 {
+		return 0
+	}
+	value, _ := strconv.ParseFloat(fields[0], 64)
+	return value
+}
+
+func writeCacheOn(status string) bool {
+	status = strings.ToLower(status)
+	return strings.HasPrefix(status, "on") || strings.Contains(status, "write-back") || strings.HasPrefix(status, "enabled")
+}
+
+func healthReport(format string) {
+	inv, err := loadDevices(allDeviceTypes...)
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+
+	result := evaluateHealth(inv)
+	// The daemon already logs the problems of every collection it makes.
+	if !inv.fromDaemon {
+		logHealth(result)
+	}
+	if format == "text" {
+		fmt.Println(strings.ToUpper(result.Severity))
+		for _, problem := range result.Problems {
+			if problem.Maintenance {
+				fmt.Printf("%v: %v (maintenance)\n", strings.ToUpper(problem.Severity), problem.Message)
+				continue
+			}
+			fmt.Printf("%v: %v\n", strings.ToUpper(problem.Severity), problem.Message)
+		}
+		return
+	}
+	r, _ := json.Marshal(result)
+	fmt.Print(string(r))
+}
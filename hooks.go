@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var hookEvents = []string{"drive-failed", "ld-degraded", "rebuild-finished", "battery-failed", "temperature-critical"}
+
+type hookConfig struct {
+	// Program runs for every event, like mdadm --monitor --program.
+	Program     string            `json:"program"`
+	Events      map[string]string `json:"events"`
+	Timeout     int               `json:"timeout"`
+	Concurrency int               `json:"concurrency"`
+}
+
+func (h hookConfig) timeout() time.Duration {
+	if h.Timeout < 1 {
+		return 30 * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+func (h hookConfig) concurrency() int {
+	if h.Concurrency < 1 {
+		return 2
+	}
+	return h.Concurrency
+}
+
+func knownHookEvent(event string) bool {
+	for _, known := range hookEvents {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// hookEvent names the event a transition stands for, or returns an empty
+// string for changes nobody hooks into.
+func hookEvent(t transition) string {
+	old, new := strings.ToLower(t.Old), strings.ToLower(t.New)
+	switch {
+	case t.DeviceType == "PD" && t.Field == "state" && strings.Contains(new, "fail"):
+		return "drive-failed"
+	case t.DeviceType == "LD" && t.Field == "status of logical device" && strings.Contains(new, "degraded"):
+		return "ld-degraded"
+	case t.DeviceType == "LD" && t.Field == "status of logical device" && new == "optimal" &&
+		(strings.Contains(old, "degraded") || strings.Contains(old, "rebuild")):
+		return "rebuild-finished"
+	case t.DeviceType == "AD" && t.Field == "battery status" && strings.Contains(new, "fail"):
+		return "battery-failed"
+	case t.DeviceType == "AD" && t.Field == "temperature status" && new == "critical":
+		return "temperature-critical"
+	}
+	return ""
+}
+
+// queueHooks puts one outbox entry per configured program for every change
+// somebody hooks into. The daemon or the notify command runs them, so a slow
+// program holds up neither a poll nor a Zabbix item.
+func queueHooks(inv inventory, changes []transition) error {
+	hooks := cfg.Hooks
+	if len(hooks.Program) < 1 && len(hooks.Events) < 1 {
+		return nil
+	}
+
+	entries := []outboxEntry{}
+	for _, t := range changes {
+		event := hookEvent(t)
+		if len(event) < 1 {
+			continue
+		}
+		programs := []string{}
+		if program, ok := hooks.Events[event]; ok {
+			programs = append(programs, program)
+		}
+		if len(hooks.Program) > 0 {
+			programs = append(programs, hooks.Program)
+		}
+
+		payload := webhookEvent(inv, t)
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		for _, program := range programs {
+			entries = append(entries, outboxEntry{ID: payload.ID, Program: program, Event: event, Payload: raw})
+		}
+	}
+	if len(entries) < 1 {
+		return nil
+	}
+	return enqueue(entries)
+}
+
+// runHook runs the program of an outbox entry. Programs get the event,
+// device type, device, old and new value as arguments and the full details
+// as ADAPTEC_* environment variables.
+func runHook(entry outboxEntry) error {
+	e := webhookPayload{}
+	if err := json.Unmarshal(entry.Payload, &e); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Hooks.timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, entry.Program, entry.Event, e.DeviceType, e.Device, e.Old, e.New)
+	cmd.Env = append(os.Environ(), hookEnv(entry.Event, e)...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v %v: %v", entry.Program, entry.Event, err)
+	}
+	return nil
+}
+
+func hookEnv(event string, e webhookPayload) []string {
+	env := []string{
+		"ADAPTEC_EVENT=" + event,
+		"ADAPTEC_HOST=" + e.Host,
+		"ADAPTEC_TIME=" + strconv.FormatInt(e.Time, 10),
+		"ADAPTEC_DEVICE_TYPE=" + e.DeviceType,
+		"ADAPTEC_DEVICE=" + e.Device,
+		"ADAPTEC_FIELD=" + e.Field,
+		"ADAPTEC_OLD=" + e.Old,
+		"ADAPTEC_NEW=" + e.New,
+		"ADAPTEC_CONTROLLER=" + strconv.Itoa(e.Controller.Number),
+		"ADAPTEC_CONTROLLER_MODEL=" + e.Controller.Model,
+		"ADAPTEC_CONTROLLER_SERIAL=" + e.Controller.SerialNumber,
+	}
+	if len(e.Name) > 0 {
+		env = append(env, "ADAPTEC_LD_NAME="+e.Name)
+	}
+	if e.Identity != nil {
+		env = append(env,
+			"ADAPTEC_SERIAL="+e.Identity.SerialNumber,
+			"ADAPTEC_WWN="+e.Identity.WWN,
+			"ADAPTEC_LOCATION="+e.Identity.Location,
+		)
+	}
+	return env
+}
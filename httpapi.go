@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type httpConfig struct {
+	Listen string `json:"listen"`
+	// The bearer token is read from TokenFile, or given as Token. It is
+	// not taken from the command line where every user could see it.
+	Token     string `json:"token"`
+	TokenFile string `json:"token file"`
+}
+
+type httpAPI struct {
+	collector *collector
+	token     string
+}
+
+func (h httpConfig) token(file string) (string, error) {
+	if len(file) < 1 {
+		return h.Token, nil
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(raw))
+	if len(token) < 1 {
+		return "", fmt.Errorf("%v is empty", file)
+	}
+	return token, nil
+}
+
+func (api httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(api.token) > 0 {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(api.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	inv, err := api.collector.current()
+	if err != nil {
+		httpError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	body, ok := route(*inv, strings.Split(strings.Trim(r.URL.Path, "/"), "/"))
+	if !ok {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+	raw, _ := json.Marshal(body)
+
+	sum := sha1.Sum(raw)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	modified := time.Unix(inv.Collected, 0).UTC()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "application/json")
+
+	if match := r.Header.Get("If-None-Match"); len(match) > 0 {
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(raw)
+}
+
+func route(inv inventory, path []string) (interface{}, bool) {
+	switch {
+	case len(path) == 1 && path[0] == "controllers":
+		return inv.Controllers, true
+	case len(path) >= 2 && path[0] == "controllers":
+		controller, err := strconv.Atoi(path[1])
+		if err != nil {
+			return nil, false
+		}
+		var found *adInfo
+		for i := range inv.Controllers {
+			if inv.Controllers[i].Controller == controller {
+				found = &inv.Controllers[i]
+			}
+		}
+		if found == nil {
+			return nil, false
+		}
+		if len(path) == 2 {
+			return *found, true
+		}
+		if len(path) == 3 && path[2] == "logical-devices" {
+			lds := []ldInfo{}
+			for _, ld := range inv.LogicalDevices {
+				if ld.Controller == controller {
+					lds = append(lds, ld)
+				}
+			}
+			return lds, true
+		}
+		if len(path) == 3 && path[2] == "physical-devices" {
+			pds := []pdInfo{}
+			for _, pd := range inv.PhysicalDevices {
+				if pd.Controller == controller {
+					pds = append(pds, pd)
+				}
+			}
+			return pds, true
+		}
+	case len(path) == 1 && path[0] == "logical-devices":
+		return inv.LogicalDevices, true
+	case len(path) == 2 && path[0] == "logical-devices":
+		for _, ld := range inv.LogicalDevices {
+			if ld.UniqueIdentifier == path[1] || ld.LdName == path[1] {
+				return ld, true
+			}
+		}
+	case len(path) == 1 && path[0] == "physical-devices":
+		return inv.PhysicalDevices, true
+	case len(path) == 2 && path[0] == "physical-devices":
+		for _, pd := range inv.PhysicalDevices {
+			if strings.EqualFold(pd.SerialNumber, path[1]) || strings.EqualFold(pd.WWN, path[1]) || pd.DeviceID == path[1] {
+				return pd, true
+			}
+		}
+	case len(path) == 1 && path[0] == "health":
+		return evaluateHealth(inv), true
+	}
+	return nil, false
+}
+
+func httpError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// collectBackend selects how getconfig output is read: "text" scrapes the
+// " : " screen output, "json" uses GETCONFIGJSON and "auto" prefers JSON
+// when the installed arcconf supports it.
+var collectBackend = "auto"
+
+var jsonAvailable *bool
+
+var adKeys = []string{
+	"controller status", "channel description", "controller model",
+	"controller serial number", "controller world wide name", "controller alarm",
+	"temperature", "installed memory", "global task priority", "performance mode",
+	"stayawake period", "defunct disk drive count", "logical devices/failed/degraded",
+	"ncq status", "copyback", "automatic failover", "background consistency check",
+	"bios", "firmware", "driver", "status",
+}
+
+// jsonSections are the nested objects that stand for a text section header
+// the parsers act on, such as the battery ("ZMM") section.
+var jsonSections = []string{"controller zmm information", "battery"}
+
+var ldKeys = []string{
+	"logical device name", "block size of member drives", "raid level",
+	"unique identifier", "status of logical device", "size", "parity space",
+	"stripe-unit size", "interface type", "device type", "read-cache setting",
+	"read-cache status", "write-cache setting", "write-cache status", "partitioned",
+	"protected by hot-spare", "bootable", "failed stripes", "power settings",
+}
+
+var pdKeys = []string{
+	"reported location", "state", "block size", "supported", "transfer speed",
+	"vendor", "model", "firmware", "serial number", "world-wide name", "reserved size", "used size",
+	"unused size", "total size", "write cache", "fru", "s.m.a.r.t.",
+	"s.m.a.r.t. warnings", "power state", "supported power state", "ssd",
+	"ncq status", "hot-spare type", "dedicated spare for", "hardware errors",
+	"medium errors", "parity errors", "link failures", "aborted commands",
+}
+
+func useJSON() bool {
+	switch collectBackend {
+	case "json":
+		return true
+	case "text":
+		return false
+	}
+	if jsonAvailable == nil {
+		available := false
+		if version := detectVersion(); version.JSON != nil {
+			available = *version.JSON
+		}
+		jsonAvailable = &available
+	}
+	return *jsonAvailable
+}
+
+func getConfigJSON(controller int, deviceType string) (map[string]interface{}, error) {
+	out, err := arcconf("getconfigjson", strconv.Itoa(controller), deviceType)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigJSON(out)
+}
+
+func parseConfigJSON(out []byte) (map[string]interface{}, error) {
+	// The JSON document is wrapped in the usual "Controllers found" and
+	// "Command completed successfully" lines.
+	text := string(out)
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("No JSON in arcconf getconfigjson output")
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func collectADJSON(controller int) (adInfo, bool) {
+	doc, err := getConfigJSON(controller, "AD")
+	if err != nil {
+		return adInfo{Controller: controller, Status: "NotPresent"}, false
+	}
+	return parseADJSON(doc, controller)
+}
+
+func parseADJSON(doc map[string]interface{}, controller int) (adInfo, bool) {
+	ad := adInfo{Controller: controller, Status: "NotPresent"}
+	for _, line := range jsonLines(doc, adKeys, isDeviceList) {
+		ad.adParserInfo(line)
+	}
+	return ad, len(ad.ControllerModel) > 0
+}
+
+func collectLDsJSON(controller int) ([]ldInfo, bool) {
+	doc, err := getConfigJSON(controller, "LD")
+	if err != nil {
+		return nil, false
+	}
+	return parseLDsJSON(doc, controller)
+}
+
+func parseLDsJSON(doc map[string]interface{}, controller int) ([]ldInfo, bool) {
+	lds := []ldInfo{}
+	for i, device := range jsonList(doc, isLogicalList) {
+		ld := ldInfo{Controller: controller, Number: strconv.Itoa(i)}
+		if number, ok := jsonValue(device, "logicalDeviceID", "logicalDeviceNumber", "ldNumber"); ok {
+			ld.Number = number
+		}
+		for _, line := range jsonLines(device, ldKeys, isMemberList) {
+			ld.ldParserInfo(line)
+		}
+		for j, member := range jsonList(device, isMemberList) {
+			status, ok := jsonValue(member, "status")
+			if !ok {
+				status = "Present"
+			}
+			if serial, ok := jsonValue(member, "serialNumber"); ok {
+				ld.ldParserInfo("Segment " + strconv.Itoa(j) + " : " + status + " () " + serial)
+			}
+		}
+		if len(ld.UniqueIdentifier) < 1 {
+			return nil, false
+		}
+		lds = append(lds, ld)
+	}
+	return lds, true
+}
+
+func collectPDsJSON(controller int) ([]pdInfo, bool) {
+	doc, err := getConfigJSON(controller, "PD")
+	if err != nil {
+		return nil, false
+	}
+	return parsePDsJSON(doc, controller)
+}
+
+func parsePDsJSON(doc map[string]interface{}, controller int) ([]pdInfo, bool) {
+	pds := []pdInfo{}
+	for _, device := range jsonList(doc, isPhysicalList) {
+		pd := pdInfo{Controller: controller}
+		for _, line := range jsonLines(device, pdKeys, nil) {
+			pd.pdParserInfo(line, controller)
+		}
+		if len(pd.DeviceID) < 1 {
+			return nil, false
+		}
+		if len(pd.State) > 1 {
+			pd.spareType()
+			pd.Identity = pdIdentity{pd.SerialNumber, pd.WWN, pd.DeviceID}
+			pds = append(pds, pd)
+		}
+	}
+	return pds, true
+}
+
+// jsonLines renders the scalar members of a JSON object as the "key : value"
+// lines the text parsers understand, so both backends share one parser. The
+// members of a nested object follow their parent's; only the objects listed in
+// jsonSections start with their key alone, like a text section header.
+func jsonLines(object map[string]interface{}, known []string, skip func(string) bool) []string {
+	lines := []string{}
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch value := object[key].(type) {
+		case map[string]interface{}:
+			if skip != nil && skip(compactKey(key)) {
+				continue
+			}
+			if header := textKey(key, jsonSections); isSection(header) {
+				lines = append(lines, header)
+			}
+			lines = append(lines, jsonLines(value, known, skip)...)
+		case []interface{}:
+			// Lists inside a device, like its phys, read as nested objects.
+			if skip != nil && skip(compactKey(key)) {
+				continue
+			}
+			for _, item := range value {
+				if item, ok := item.(map[string]interface{}); ok {
+					lines = append(lines, jsonLines(item, known, skip)...)
+				}
+			}
+		default:
+			lines = append(lines, textKey(key, known)+" : "+jsonScalar(value))
+		}
+	}
+	return lines
+}
+
+// jsonList collects the objects of every array whose key matches. Keys are
+// walked in sorted order so devices keep their position between runs.
+func jsonList(v interface{}, match func(string) bool) []map[string]interface{} {
+	list := []map[string]interface{}{}
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return list
+	}
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch value := object[key].(type) {
+		case []interface{}:
+			if !match(compactKey(key)) {
+				continue
+			}
+			for _, item := range value {
+				if item, ok := item.(map[string]interface{}); ok {
+					list = append(list, item)
+				}
+			}
+		case map[string]interface{}:
+			list = append(list, jsonList(value, match)...)
+		}
+	}
+	return list
+}
+
+func jsonValue(object map[string]interface{}, keys ...string) (string, bool) {
+	for _, key := range keys {
+		for name, value := range object {
+			if compactKey(name) == compactKey(key) {
+				return jsonScalar(value), true
+			}
+		}
+	}
+	return "", false
+}
+
+func jsonScalar(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		if value {
+			return "Yes"
+		}
+		return "No"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		return value
+	}
+	return fmt.Sprint(value)
+}
+
+// textKey maps a JSON member such as "controllerSerialNumber" to the text
+// key "controller serial number"; unknown members are split on case changes.
+func textKey(key string, known []string) string {
+	compact := compactKey(key)
+	for _, text := range known {
+		if compactKey(text) == compact {
+			return text
+		}
+	}
+	words := []rune{}
+	previous := ' '
+	for _, r := range key {
+		if unicode.IsUpper(r) && unicode.IsLower(previous) {
+			words = append(words, ' ')
+		}
+		words = append(words, unicode.ToLower(r))
+		previous = r
+	}
+	return string(words)
+}
+
+func isSection(key string) bool {
+	for _, section := range jsonSections {
+		if section == key {
+			return true
+		}
+	}
+	return false
+}
+
+func compactKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, key)
+}
+
+func isLogicalList(key string) bool {
+	return strings.Contains(key, "logicaldevice") || strings.Contains(key, "logicaldrive")
+}
+
+func isPhysicalList(key string) bool {
+	return strings.Contains(key, "physicaldevice") || strings.Contains(key, "physicaldrive") ||
+		strings.Contains(key, "harddrive")
+}
+
+func isMemberList(key string) bool {
+	return strings.Contains(key, "segment") || strings.Contains(key, "chunk") ||
+		strings.Contains(key, "member")
+}
+
+func isDeviceList(key string) bool {
+	return isLogicalList(key) || isPhysicalList(key)
+}
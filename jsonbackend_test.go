@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The fixtures are the text and JSON output of the same configuration, so
+// both backends have to produce the same devices.
+func parityFixtures(t *testing.T, deviceType string) (string, map[string]interface{}) {
+	text, err := ioutil.ReadFile(filepath.Join("testdata", "getconfig_"+deviceType+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "getconfigjson_"+deviceType+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := parseConfigJSON(raw)
+	if err != nil {
+		t.Fatalf("getconfigjson %v: %v", deviceType, err)
+	}
+	return string(text), doc
+}
+
+// setupParity pins the profile of the arcconf release the fixtures come from
+// and keeps unknown keys, which have to match too.
+func setupParity(t *testing.T) {
+	profile, extra := currentProfile, captureExtra
+	for i := range profiles {
+		if profiles[i].Name == "uniform" {
+			currentProfile = &profiles[i]
+		}
+	}
+	captureExtra = true
+	t.Cleanup(func() {
+		currentProfile, captureExtra = profile, extra
+	})
+}
+
+func TestADParity(t *testing.T) {
+	setupParity(t)
+	text, doc := parityFixtures(t, "ad")
+
+	want := parseAD(text, 1)
+	got, ok := parseADJSON(doc, 1)
+	if !ok {
+		t.Fatal("JSON backend rejected the controller")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON controller differs from text\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLDParity(t *testing.T) {
+	setupParity(t)
+	text, doc := parityFixtures(t, "ld")
+
+	want := parseLDs(text, 1)
+	got, ok := parseLDsJSON(doc, 1)
+	if !ok {
+		t.Fatal("JSON backend rejected the logical devices")
+	}
+	if len(want) != 1 || !reflect.DeepEqual(want[0].Members, []string{"WD-AAA", "WD-CCC"}) {
+		t.Fatalf("text backend found members %+v, want the two present drives", want)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON logical devices differ from text\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestPDParity(t *testing.T) {
+	setupParity(t)
+	text, doc := parityFixtures(t, "pd")
+
+	want := parsePDs(text, 1)
+	got, ok := parsePDsJSON(doc, 1)
+	if !ok {
+		t.Fatal("JSON backend rejected the physical devices")
+	}
+	if len(want) != 3 {
+		t.Fatalf("text backend found %v drives, want 3", len(want))
+	}
+	if want[1].Extra["negotiated physical link rate"] != "3 Gbps" || want[1].Extra["device type"] != "Hard drive" {
+		t.Fatalf("text backend lost unknown keys: %v", want[1].Extra)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON physical devices differ from text\n got %+v\nwant %+v", got, want)
+	}
+}
+
+// Members of a JSON document are walked in map order by Go, the devices
+// must still come out in the same order on every run.
+func TestJSONListOrder(t *testing.T) {
+	doc := map[string]interface{}{
+		"b": map[string]interface{}{"LogicalDrive": []interface{}{map[string]interface{}{"n": "3"}}},
+		"a": map[string]interface{}{"LogicalDrive": []interface{}{map[string]interface{}{"n": "1"}, map[string]interface{}{"n": "2"}}},
+		"c": map[string]interface{}{"LogicalDrive": []interface{}{map[string]interface{}{"n": "4"}}},
+	}
+	for run := 0; run < 20; run++ {
+		order := ""
+		for _, device := range jsonList(doc, isLogicalList) {
+			order += device["n"].(string)
+		}
+		if order != "1234" {
+			t.Fatalf("run %v: devices in order %v, want 1234", run, order)
+		}
+	}
+}
+
+// A nested object named like a field must not reach the parsers as a key
+// without a value.
+func TestJSONNestedField(t *testing.T) {
+	doc, err := parseConfigJSON([]byte(`{"Controller":{"controllerModel":"Adaptec ASR8405","firmware":{"version":"7.5"},"status":{"state":"Optimal"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad, ok := parseADJSON(doc, 1)
+	if !ok {
+		t.Fatal("JSON backend rejected the controller")
+	}
+	if len(ad.Firmware) > 0 || ad.Status != "NotPresent" {
+		t.Errorf("nested objects were taken as values: firmware %q, status %q", ad.Firmware, ad.Status)
+	}
+}
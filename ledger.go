@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	ledgerState  = "ledger.json"
+	ledgerEvents = 200
+)
+
+type driveLedger struct {
+	Drives  map[string]*driveRecord `json:"drives"`
+	Events  []ledgerEvent           `json:"events"`
+	Pending []ledgerEvent           `json:"pending"`
+}
+
+type driveRecord struct {
+	SerialNumber   string      `json:"serial number"`
+	FirstSeen      int64       `json:"first seen"`
+	LastSeen       int64       `json:"last seen"`
+	Present        bool        `json:"present"`
+	Location       string      `json:"location"`
+	SlotHistory    []slotEntry `json:"slot history"`
+	LogicalDevices []string    `json:"logical devices"`
+}
+
+type slotEntry struct {
+	Location string `json:"location"`
+	Since    int64  `json:"since"`
+}
+
+type ledgerEvent struct {
+	Time         int64  `json:"time"`
+	Event        string `json:"event"`
+	SerialNumber string `json:"serial number"`
+	Location     string `json:"location"`
+	Previous     string `json:"previous"`
+}
+
+// updateLedger compares the drives found by this collection with the stored
+// inventory and returns the inserted, removed, replaced and moved events.
+func updateLedger(inv inventory, now int64) ([]ledgerEvent, error) {
+	unlock, err := lockState(ledgerState)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ledger := driveLedger{Drives: map[string]*driveRecord{}}
+	if err := loadState(ledgerState, &ledger); err != nil {
+		return nil, err
+	}
+	if ledger.Drives == nil {
+		ledger.Drives = map[string]*driveRecord{}
+	}
+	// The first run only records the drives already installed.
+	baseline := len(ledger.Drives) < 1
+
+	members := map[string][]string{}
+	for _, ld := range inv.LogicalDevices {
+		for _, serial := range ld.Members {
+			members[serial] = append(members[serial], ld.UniqueIdentifier)
+		}
+	}
+
+	// Remember who last sat in every slot before this run to tell a
+	// replacement from a plain insertion. A removed drive still counts, a
+	// swap often spans more than one collection.
+	occupant := map[string]string{}
+	for serial, record := range ledger.Drives {
+		last, ok := ledger.Drives[occupant[record.Location]]
+		if !ok || record.LastSeen > last.LastSeen || (record.LastSeen == last.LastSeen && record.Present) {
+			occupant[record.Location] = serial
+		}
+	}
+
+	events := []ledgerEvent{}
+	seen := map[string]bool{}
+	for _, pd := range inv.PhysicalDevices {
+		if len(pd.SerialNumber) < 1 {
+			continue
+		}
+		seen[pd.SerialNumber] = true
+		record, known := ledger.Drives[pd.SerialNumber]
+		if !known {
+			record = &driveRecord{SerialNumber: pd.SerialNumber, FirstSeen: now}
+			ledger.Drives[pd.SerialNumber] = record
+		}
+
+		switch {
+		case !known || !record.Present:
+			event := ledgerEvent{Time: now, Event: "inserted", SerialNumber: pd.SerialNumber, Location: pd.DeviceID}
+			if previous, ok := occupant[pd.DeviceID]; ok && previous != pd.SerialNumber {
+				event.Event = "replaced"
+				event.Previous = previous
+			}
+			events = append(events, event)
+		case record.Location != pd.DeviceID:
+			events = append(events, ledgerEvent{
+				Time:         now,
+				Event:        "moved",
+				SerialNumber: pd.SerialNumber,
+				Location:     pd.DeviceID,
+				Previous:     record.Location,
+			})
+		}
+
+		if record.Location != pd.DeviceID {
+			record.SlotHistory = append(record.SlotHistory, slotEntry{Location: pd.DeviceID, Since: now})
+		}
+		record.Location = pd.DeviceID
+		record.LastSeen = now
+		record.Present = true
+		record.LogicalDevices = members[pd.SerialNumber]
+	}
+
+	serials := []string{}
+	for serial := range ledger.Drives {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	for _, serial := range serials {
+		record := ledger.Drives[serial]
+		if record.Present && !seen[serial] {
+			record.Present = false
+			events = append(events, ledgerEvent{Time: now, Event: "removed", SerialNumber: serial, Location: record.Location})
+		}
+	}
+
+	if baseline {
+		events = []ledgerEvent{}
+	}
+	ledger.Events = append(ledger.Events, events...)
+	if len(ledger.Events) > ledgerEvents {
+		ledger.Events = ledger.Events[len(ledger.Events)-ledgerEvents:]
+	}
+	ledger.Pending = append(ledger.Pending, events...)
+	if len(ledger.Pending) > ledgerEvents {
+		ledger.Pending = ledger.Pending[len(ledger.Pending)-ledgerEvents:]
+	}
+	return events, saveState(ledgerState, ledger)
+}
+
+func ledgerReport(format string) {
+	inv, err := loadInventory()
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+
+	if _, err := updateLedger(inv, time.Now().Unix()); err != nil {
+		fmt.Printf("Cannot update drive ledger\n - %v", err)
+		os.Exit(1)
+	}
+
+	// Events found by the daemon between two runs of this command are kept
+	// pending until they are reported here.
+	unlock, err := lockState(ledgerState)
+	if err != nil {
+		fmt.Printf("Cannot lock drive ledger\n - %v", err)
+		os.Exit(1)
+	}
+	defer unlock()
+	ledger := driveLedger{}
+	if err := loadState(ledgerState, &ledger); err != nil {
+		fmt.Printf("Cannot read drive ledger\n - %v", err)
+		os.Exit(1)
+	}
+	if format == "inventory" {
+		r, _ := json.Marshal(ledger)
+		fmt.Print(string(r))
+		return
+	}
+	events := ledger.Pending
+	if events == nil {
+		events = []ledgerEvent{}
+	}
+	ledger.Pending = nil
+	if err := saveState(ledgerState, ledger); err != nil {
+		fmt.Printf("Cannot save drive ledger\n - %v", err)
+		os.Exit(1)
+	}
+
+	switch format {
+	case "json":
+		r, _ := json.Marshal(events)
+		fmt.Print(string(r))
+	default:
+		for _, e := range events {
+			fmt.Println(ledgerEventText(e))
+		}
+	}
+}
+
+func ledgerEventText(e ledgerEvent) string {
+	stamp := time.Unix(e.Time, 0).UTC().Format("2006-01-02 15:04:05")
+	switch e.Event {
+	case "replaced":
+		return fmt.Sprintf("%v drive %v replaced %v in %v", stamp, e.SerialNumber, e.Previous, e.Location)
+	case "moved":
+		return fmt.Sprintf("%v drive %v moved from %v to %v", stamp, e.SerialNumber, e.Previous, e.Location)
+	case "removed":
+		return fmt.Sprintf("%v drive %v removed from %v", stamp, e.SerialNumber, e.Location)
+	}
+	return fmt.Sprintf("%v drive %v %v in %v", stamp, e.SerialNumber, e.Event, e.Location)
+}
@@ -3,71 +3,52 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
-	"strconv"
 	"strings"
 )
 
 type ldInfo struct {
-	LdName              string `json:"logical device name"`
-	BlockSize           string `json:"block size of member drives"`
-	RaidLevel           string `json:"raid level"`
-	UniqueIdentifier    string `json:"unique identifier"`
-	StatusLD            string `json:"status of logical device"`
-	Size                string `json:"size"`
-	ParitySpace         string `json:"parity space"`
-	StripeUnitSize      string `json:"stripe-unit size"`
-	InterfaceType       string `json:"interface type"`
-	DeviceType          string `json:"device type"`
-	ReadCacheSettings   string `json:"read-cache setting"`
-	ReadCacheStatus     string `json:"read-cache status"`
-	WriteCacheSettings  string `json:"write-cache setting"`
-	WriteCacheStatus    string `json:"write-cache status"`
-	Partitioned         string `json:"partitioned"`
-	ProtectedByHotSpare string `json:"protected by hot-spare"`
-	Bootable            string `json:"bootable"`
-	FailedStripes       string `json:"failed stripes"`
-	PowerSettings       string `json:"power settings"`
+	Controller          int               `json:"controller"`
+	Number              string            `json:"logical device number"`
+	LdName              string            `json:"logical device name"`
+	BlockSize           string            `json:"block size of member drives"`
+	RaidLevel           string            `json:"raid level"`
+	UniqueIdentifier    string            `json:"unique identifier"`
+	StatusLD            string            `json:"status of logical device"`
+	Size                string            `json:"size"`
+	SizeMB              int               `json:"size mb"`
+	ParitySpace         string            `json:"parity space"`
+	StripeUnitSize      string            `json:"stripe-unit size"`
+	InterfaceType       string            `json:"interface type"`
+	DeviceType          string            `json:"device type"`
+	ReadCacheSettings   string            `json:"read-cache setting"`
+	ReadCacheStatus     string            `json:"read-cache status"`
+	WriteCacheSettings  string            `json:"write-cache setting"`
+	WriteCacheStatus    string            `json:"write-cache status"`
+	Partitioned         string            `json:"partitioned"`
+	ProtectedByHotSpare string            `json:"protected by hot-spare"`
+	Bootable            string            `json:"bootable"`
+	FailedStripes       string            `json:"failed stripes"`
+	PowerSettings       string            `json:"power settings"`
+	Members             []string          `json:"members"`
+	Extra               map[string]string `json:"extra,omitempty"`
+	Maintenance         bool              `json:"maintenance"`
 }
 
 func ldDiscovery() {
-	deviceType := "LD"
 	disks := []discoveryDevice{}
 
-	controllers, err := controllersCount()
-	if err != nil {
-		fmt.Printf("Cannot check lspci adaptec controllers\n - %v", err)
-		os.Exit(1)
-	}
-
-	if controllers > 0 {
-		bin, binErr := getBin("arcconf")
-		if binErr != nil {
-			fmt.Printf("arcconf - not found in system PATH\n - %v", binErr)
-			os.Exit(0)
-		}
-
-		for controller := 1; controller <= controllers; controller++ {
-			args := []string{"getconfig", strconv.Itoa(controller), deviceType, "nologs"}
-			cmd := exec.Command(bin, args...)
-			out, err := cmd.Output()
-			if err != nil {
-				fmt.Printf("Error %v", err)
-			}
-			parts := strings.Split(string(out), "Logical Device number")
-			for _, ldinfo := range parts {
-				ld := discoveryDevice{}
-
-				for _, ldstat := range strings.Split(ldinfo, "\n") {
-					ld.ldDiscoveryParser(ldstat, controller)
-				}
-				if len(ld.Present) > 1 {
-					disks = append(disks, ld)
-				}
-			}
+	inv := inventoryOrExit("LD")
+	for _, ld := range inv.LogicalDevices {
+		if len(ld.UniqueIdentifier) > 1 {
+			disks = append(disks, discoveryDevice{
+				DeviceID:    ld.UniqueIdentifier,
+				DeviceType:  "LD",
+				DeviceAlias: ld.LdName,
+				Present:     ld.UniqueIdentifier,
+			})
 		}
 	}
+	maintenanceFlags(disks)
 	data := data{Data: disks}
 	//r, _ := json.MarshalIndent(data, "", " ")
 	r, _ := json.Marshal(data)
@@ -75,67 +56,45 @@ func ldDiscovery() {
 }
 
 func ldStats(ldName string) {
-	deviceType := "LD"
 	devices := map[string]ldInfo{}
 
-	controllers, err := controllersCount()
-	if err != nil {
-		fmt.Print("Cannot check lspci adaptec controllers.")
-		os.Exit(1)
-	}
-	bin, binErr := getBin("arcconf")
-	if binErr != nil {
-		fmt.Print("Arcconf not found.")
-		os.Exit(0)
+	inv := inventoryOrExit("LD")
+	for _, ld := range inv.LogicalDevices {
+		devices[ld.UniqueIdentifier] = ld
 	}
 
-	for controller := 1; controller <= controllers; controller++ {
-		args := []string{"getconfig", strconv.Itoa(controller), deviceType, "nologs"}
-		cmd := exec.Command(bin, args...)
-		out, err := cmd.Output()
-		if err != nil {
-			fmt.Printf("Error %v", err)
-		}
-
-		parts := strings.Split(string(out), "Device #")
-
-		for _, pdinfo := range parts {
-			ld := ldInfo{}
-
-			for _, pdstat := range strings.Split(pdinfo, "\n") {
-				ld.ldParserInfo(pdstat)
-			}
-			devices[ld.UniqueIdentifier] = ld
-		}
-
-		if _, ok := devices[ldName]; ok {
-			//r, _ := json.MarshalIndent(devices[ldName], "", "  ")
-			r, _ := json.Marshal(devices[ldName])
-			fmt.Print(string(r))
-		} else {
-			fmt.Printf("LD not exist %v", ldName)
-			os.Exit(1)
-		}
+	if ld, ok := devices[ldName]; ok {
+		unsupported(len(ld.StatusLD) < 1)
+		ld.Maintenance = underMaintenance(activeMaintenance(), "LD", ld.UniqueIdentifier, ld.LdName)
+		//r, _ := json.MarshalIndent(ld, "", "  ")
+		printStats(ld)
+	} else {
+		unsupported(true)
+		notExist("LD", ldName)
 	}
 }
 
-func (d *discoveryDevice) ldDiscoveryParser(line string, controller int) error {
+func (ld *ldInfo) ldParserInfo(line string) error {
 	split := strings.Split(line, " : ")
 	match := strings.ToLower(strings.TrimSpace(split[0]))
-	if match == "logical device name" {
-		d.DeviceAlias = strings.TrimSpace(split[1])
+	if strings.Contains(match, "segment") && len(split) > 1 {
+		// "Segment 0" or "Group 0, Segment 0" on RAID 10/50/60:
+		// "Present (953869MB, SATA, HDD, Connector:0, Device:0)   WD-WCC4M1234567"
+		// A missing member has no serial: "Missing" or "Missing (...)".
+		status := strings.TrimSpace(split[1])
+		end := strings.LastIndex(status, ")")
+		if end < 0 || strings.HasPrefix(strings.ToLower(status), "missing") {
+			return nil
+		}
+		segment := strings.Fields(status[end+1:])
+		if len(segment) > 0 {
+			ld.Members = append(ld.Members, segment[len(segment)-1])
+		}
+		return nil
 	}
-	if match == "unique identifier" {
-		d.DeviceID = strings.TrimSpace(split[1])
-		d.Present = strings.TrimSpace(split[1])
+	if len(split) < 2 {
+		return nil
 	}
-	d.DeviceType = "LD"
-	return nil
-}
-
-func (ld *ldInfo) ldParserInfo(line string) error {
-	split := strings.Split(line, " : ")
-	match := strings.ToLower(strings.TrimSpace(split[0]))
 	switch match {
 	case "logical device name":
 		ld.LdName = strings.TrimSpace(split[1])
@@ -149,6 +108,7 @@ func (ld *ldInfo) ldParserInfo(line string) error {
 		ld.StatusLD = strings.TrimSpace(split[1])
 	case "size":
 		ld.Size = strings.TrimSpace(split[1])
+		ld.SizeMB = parseSizeMB(ld.Size)
 	case "parity space":
 		ld.ParitySpace = strings.TrimSpace(split[1])
 	case "stripe-unit size":
@@ -175,6 +135,8 @@ func (ld *ldInfo) ldParserInfo(line string) error {
 		ld.FailedStripes = strings.TrimSpace(split[1])
 	case "power settings":
 		ld.PowerSettings = strings.TrimSpace(split[1])
+	default:
+		ld.Extra = extraField(ld.Extra, match, split)
 	}
 	return nil
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const eventsState = "events.json"
+
+var (
+	logElement   = regexp.MustCompile(`<(\w+)((?:\s+[\w:.-]+="[^"]*")*)\s*/?>`)
+	logAttribute = regexp.MustCompile(`([\w:.-]+)="([^"]*)"`)
+)
+
+type logEntry struct {
+	Tag   string            `json:"tag"`
+	Raw   string            `json:"raw"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+type driveLog struct {
+	Controller      int    `json:"controller"`
+	DeviceID        string `json:"device id"`
+	SerialNumber    string `json:"serial number"`
+	DeadEntries     int    `json:"dead drive entries"`
+	ErrorEntries    int    `json:"device error entries"`
+	HardwareErrors  int    `json:"hardware errors"`
+	MediumErrors    int    `json:"medium errors"`
+	ParityErrors    int    `json:"parity errors"`
+	LinkFailures    int    `json:"link failures"`
+	AbortedCommands int    `json:"aborted commands"`
+	SmartWarnings   int    `json:"smart warnings"`
+}
+
+type eventEntry struct {
+	Controller int    `json:"controller"`
+	Time       int64  `json:"time"`
+	Severity   string `json:"severity"`
+	Text       string `json:"text"`
+}
+
+type eventCursor struct {
+	Time int64    `json:"time"`
+	Seen []string `json:"seen"`
+}
+
+func getLogs(controller int, logType string) ([]logEntry, error) {
+	out, err := arcconf("getlogs", strconv.Itoa(controller), logType)
+	if err != nil {
+		return nil, err
+	}
+	entries := []logEntry{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		element := logElement.FindStringSubmatch(line)
+		if element == nil || element[1] == "ControllerLog" {
+			continue
+		}
+		entry := logEntry{Tag: element[1], Raw: line, Attrs: map[string]string{}}
+		for _, attr := range logAttribute.FindAllStringSubmatch(element[2], -1) {
+			entry.Attrs[attr[1]] = strings.TrimSpace(attr[2])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// controllerLogs returns one log of every controller, index 0 being
+// controller 1. A running daemon serves the copy of its last poll.
+func controllerLogs(logType string) ([][]logEntry, error) {
+	if reply, err := askDaemon("logs"); err == nil {
+		if logs, ok := reply.Logs[logType]; ok {
+			return logs, nil
+		}
+	}
+	return collectLogs(logType)
+}
+
+func collectLogs(logType string) ([][]logEntry, error) {
+	controllers, err := controllersCount()
+	if err != nil {
+		return nil, err
+	}
+	logs := [][]logEntry{}
+	for controller := 1; controller <= controllers; controller++ {
+		entries, err := getLogs(controller, logType)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, entries)
+	}
+	return logs, nil
+}
+
+func (e logEntry) attr(names ...string) string {
+	for _, name := range names {
+		if value, ok := e.Attrs[name]; ok && len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+func (e logEntry) count(name string) int {
+	count, _ := strconv.Atoi(e.Attrs[name])
+	return count
+}
+
+func logsDevice(driveName string) {
+	deviceLogs, err := controllerLogs("device")
+	if err != nil {
+		fmt.Printf("Error %v", err)
+		os.Exit(1)
+	}
+	deadLogs, err := controllerLogs("dead")
+	if err != nil {
+		fmt.Printf("Error %v", err)
+		os.Exit(1)
+	}
+
+	drives := map[string]*driveLog{}
+	drive := func(controller int, entry logEntry) *driveLog {
+		id := entry.attr("serialNumber")
+		if len(id) < 1 {
+			id = "Controller " + strconv.Itoa(controller) + ", Device " + entry.attr("deviceID")
+		}
+		if _, ok := drives[id]; !ok {
+			drives[id] = &driveLog{
+				Controller:   controller,
+				DeviceID:     entry.attr("deviceID"),
+				SerialNumber: entry.attr("serialNumber"),
+			}
+		}
+		return drives[id]
+	}
+
+	for i, deviceLog := range deviceLogs {
+		controller := i + 1
+		for _, entry := range deviceLog {
+			d := drive(controller, entry)
+			d.ErrorEntries++
+			d.HardwareErrors += entry.count("hwErrors")
+			d.MediumErrors += entry.count("mediumErrors")
+			d.ParityErrors += entry.count("numParityErrors")
+			d.LinkFailures += entry.count("linkFailures")
+			d.AbortedCommands += entry.count("abortedCmds")
+			d.SmartWarnings += entry.count("smartWarning")
+		}
+
+	}
+	for i, deadLog := range deadLogs {
+		for _, entry := range deadLog {
+			drive(i+1, entry).DeadEntries++
+		}
+	}
+
+	if len(driveName) < 1 {
+		r, _ := json.Marshal(drives)
+		fmt.Print(string(r))
+		return
+	}
+	if d, ok := drives[driveName]; ok {
+		r, _ := json.Marshal(d)
+		fmt.Print(string(r))
+	} else {
+		// A drive without log entries is a healthy drive, not an error.
+		r, _ := json.Marshal(driveLog{SerialNumber: driveName})
+		fmt.Print(string(r))
+	}
+}
+
+func logsEvent(format string) {
+	eventLogs, err := controllerLogs("event")
+	if err != nil {
+		fmt.Printf("Error %v", err)
+		os.Exit(1)
+	}
+
+	unlock, err := lockState(eventsState)
+	if err != nil {
+		fmt.Printf("Cannot lock event cursor\n - %v", err)
+		os.Exit(1)
+	}
+	defer unlock()
+	cursors := map[string]eventCursor{}
+	if err := loadState(eventsState, &cursors); err != nil {
+		fmt.Printf("Cannot read event cursor\n - %v", err)
+		os.Exit(1)
+	}
+
+	events := []eventEntry{}
+	for i, entries := range eventLogs {
+		controller := i + 1
+		key := strconv.Itoa(controller)
+		cursor := cursors[key]
+		seen := map[string]bool{}
+		for _, raw := range cursor.Seen {
+			seen[raw] = true
+		}
+		next := eventCursor{Time: cursor.Time}
+
+		for _, entry := range entries {
+			stamp, _ := strconv.ParseInt(entry.attr("time", "timeStamp"), 10, 64)
+			if stamp < cursor.Time || (stamp == cursor.Time && seen[entry.Raw]) {
+				continue
+			}
+			events = append(events, eventEntry{
+				Controller: controller,
+				Time:       stamp,
+				Severity:   eventSeverity(entry),
+				Text:       eventText(entry),
+			})
+			if stamp > next.Time {
+				next = eventCursor{Time: stamp}
+			}
+		}
+		// Entries sharing the cursor second are remembered so that the next
+		// poll does not report them twice.
+		for _, entry := range entries {
+			stamp, _ := strconv.ParseInt(entry.attr("time", "timeStamp"), 10, 64)
+			if stamp == next.Time {
+				next.Seen = append(next.Seen, entry.Raw)
+			}
+		}
+		cursors[key] = next
+	}
+
+	if err := saveState(eventsState, cursors); err != nil {
+		fmt.Printf("Cannot save event cursor\n - %v", err)
+		os.Exit(1)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	if format == "json" {
+		r, _ := json.Marshal(events)
+		fmt.Print(string(r))
+		return
+	}
+	for _, e := range events {
+		stamp := time.Unix(e.Time, 0).UTC().Format("2006-01-02 15:04:05")
+		fmt.Printf("%v %v controller %v: %v\n", stamp, e.Severity, e.Controller, e.Text)
+	}
+}
+
+func eventSeverity(entry logEntry) string {
+	severity := strings.ToUpper(entry.attr("severity", "level", "priority"))
+	if len(severity) < 1 {
+		return "INFO"
+	}
+	return severity
+}
+
+func eventText(entry logEntry) string {
+	text := entry.attr("description", "text", "message", "data")
+	if len(text) > 0 {
+		return text
+	}
+	keys := []string{}
+	for key := range entry.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{entry.Tag}
+	for _, key := range keys {
+		parts = append(parts, key+"="+entry.Attrs[key])
+	}
+	return strings.Join(parts, " ")
+}
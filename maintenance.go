@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const maintenanceState = "maintenance.json"
+
+type maintenanceEntry struct {
+	DeviceType string `json:"device type"`
+	Device     string `json:"device"`
+	Reason     string `json:"reason"`
+	Created    int64  `json:"created"`
+	Expires    int64  `json:"expires"`
+}
+
+// loadMaintenance returns the active suppressions and drops the expired ones
+// from the state file. The caller holds the maintenance lock.
+func loadMaintenance(now int64) ([]maintenanceEntry, error) {
+	entries := []maintenanceEntry{}
+	if err := loadState(maintenanceState, &entries); err != nil {
+		return nil, err
+	}
+
+	active := []maintenanceEntry{}
+	for _, entry := range entries {
+		if entry.Expires > now {
+			active = append(active, entry)
+		}
+	}
+	if len(active) < len(entries) {
+		if err := saveState(maintenanceState, active); err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+func underMaintenance(entries []maintenanceEntry, deviceType string, ids ...string) bool {
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.DeviceType, deviceType) {
+			continue
+		}
+		for _, id := range ids {
+			if len(id) > 0 && strings.EqualFold(entry.Device, id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// activeMaintenance is used by discovery and stats, where a broken state
+// file must not hide the device data itself.
+func activeMaintenance() []maintenanceEntry {
+	unlock, err := lockState(maintenanceState)
+	if err != nil {
+		return []maintenanceEntry{}
+	}
+	defer unlock()
+	entries, err := loadMaintenance(time.Now().Unix())
+	if err != nil {
+		return []maintenanceEntry{}
+	}
+	return entries
+}
+
+func maintenanceFlags(devices []discoveryDevice) {
+	entries := activeMaintenance()
+	for i, device := range devices {
+		devices[i].Maintenance = "0"
+		ids := append([]string{device.DeviceID, device.DeviceAlias}, device.ids...)
+		if underMaintenance(entries, device.DeviceType, ids...) {
+			devices[i].Maintenance = "1"
+		}
+	}
+}
+
+func maintenance(deviceType string, deviceName string, duration time.Duration, reason string, remove bool) {
+	if duration <= 0 && !remove {
+		fmt.Printf("Maintenance time must be positive, got %v", duration)
+		os.Exit(1)
+	}
+	now := time.Now().Unix()
+	unlock, err := lockState(maintenanceState)
+	if err != nil {
+		fmt.Printf("Cannot lock maintenance entries\n - %v", err)
+		os.Exit(1)
+	}
+	defer unlock()
+	entries, err := loadMaintenance(now)
+	if err != nil {
+		fmt.Printf("Cannot read maintenance entries\n - %v", err)
+		os.Exit(1)
+	}
+
+	if len(deviceName) > 0 {
+		deviceType = strings.ToUpper(deviceType)
+		if _, ok := map[string]bool{"AD": true, "LD": true, "PD": true}[deviceType]; !ok {
+			fmt.Printf("Unknown device type %v", deviceType)
+			os.Exit(1)
+		}
+
+		kept := []maintenanceEntry{}
+		for _, entry := range entries {
+			if entry.DeviceType != deviceType || !strings.EqualFold(entry.Device, deviceName) {
+				kept = append(kept, entry)
+			}
+		}
+		if !remove {
+			kept = append(kept, maintenanceEntry{
+				DeviceType: deviceType,
+				Device:     deviceName,
+				Reason:     reason,
+				Created:    now,
+				Expires:    now + int64(duration.Seconds()),
+			})
+		}
+		entries = kept
+		if err := saveState(maintenanceState, entries); err != nil {
+			fmt.Printf("Cannot save maintenance entries\n - %v", err)
+			os.Exit(1)
+		}
+	}
+
+	r, _ := json.Marshal(entries)
+	fmt.Print(string(r))
+}
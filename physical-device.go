@@ -4,72 +4,72 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 )
 
 type pdInfo struct {
-	DeviceID             string `json:"device id"`
-	State                string `json:"state"`
-	BlockSize            string `json:"block size"`
-	Supported            string `json:"supported"`
-	TransferSpeed        string `json:"transfer speed"`
-	Vendor               string `json:"vendor"`
-	Model                string `json:"model"`
-	Firmware             string `json:"firmware"`
-	SerialNumber         string `json:"serial number"`
-	ReservedSize         string `json:"reserved size"`
-	UsedSize             string `json:"used size"`
-	UnusedSize           string `json:"unused size"`
-	TotalSize            string `json:"total size"`
-	WriteCache           string `json:"write cache"`
-	FRU                  string `json:"fru"`
-	Smart                string `json:"s.m.a.r.t."`
-	SmartWarnings        int    `json:"s.m.a.r.t. warnings"`
-	PowerState           string `json:"power state"`
-	SupportedPowerStates string `json:"supported power state"`
-	SSD                  string `json:"ssd"`
-	NCQ                  string `json:"ncq"`
+	Controller           int      `json:"controller"`
+	DeviceID             string   `json:"device id"`
+	State                string   `json:"state"`
+	BlockSize            string   `json:"block size"`
+	Supported            string   `json:"supported"`
+	TransferSpeed        string   `json:"transfer speed"`
+	Vendor               string   `json:"vendor"`
+	Model                string   `json:"model"`
+	Firmware             string   `json:"firmware"`
+	SerialNumber         string   `json:"serial number"`
+	WWN                  string   `json:"world-wide name"`
+	ReservedSize         string   `json:"reserved size"`
+	UsedSize             string   `json:"used size"`
+	UnusedSize           string   `json:"unused size"`
+	TotalSize            string   `json:"total size"`
+	TotalSizeMB          int      `json:"total size mb"`
+	WriteCache           string   `json:"write cache"`
+	FRU                  string   `json:"fru"`
+	Smart                string   `json:"s.m.a.r.t."`
+	PowerState           string   `json:"power state"`
+	SupportedPowerStates string   `json:"supported power state"`
+	SSD                  string   `json:"ssd"`
+	NCQ                  string   `json:"ncq"`
+	HotSpare             string   `json:"hot spare"`
+	DedicatedTo          []string `json:"dedicated to"`
+	pdCounters
+	Delta       pdCounters        `json:"delta"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Identity    pdIdentity        `json:"identity"`
+	Maintenance bool              `json:"maintenance"`
 }
 
-func pdDiscovery() {
-	deviceType := "PD"
-	disks := []discoveryDevice{}
-
-	controllers, err := controllersCount()
-	if err != nil {
-		fmt.Printf("Cannot check lspci adaptec controllers\n - %v", err)
-		os.Exit(1)
-	}
+type pdIdentity struct {
+	SerialNumber string `json:"serial"`
+	WWN          string `json:"wwn"`
+	Location     string `json:"location"`
+}
 
-	if controllers > 0 {
-		bin, binErr := getBin("arcconf")
-		if binErr != nil {
-			fmt.Printf("arcconf - not found in system PATH\n - %v", binErr)
-			os.Exit(0)
-		}
+type pdCounters struct {
+	HardwareErrors  int `json:"hardware errors"`
+	MediumErrors    int `json:"medium errors"`
+	ParityErrors    int `json:"parity errors"`
+	LinkFailures    int `json:"link failures"`
+	AbortedCommands int `json:"aborted commands"`
+	SmartWarnings   int `json:"s.m.a.r.t. warnings"`
+}
 
-		for controller := 1; controller <= controllers; controller++ {
-			args := []string{"getconfig", strconv.Itoa(controller), deviceType, "nologs"}
-			cmd := exec.Command(bin, args...)
-			out, err := cmd.Output()
-			if err != nil {
-				fmt.Printf("Error %v", err)
-			}
-			parts := strings.Split(string(out), "Device #")
-			for _, pdinfo := range parts {
-				pd := discoveryDevice{}
+func pdDiscovery() {
+	disks := []discoveryDevice{}
 
-				for _, pdstat := range strings.Split(pdinfo, "\n") {
-					pd.pdDiscoveryParser(pdstat, controller)
-				}
-				if len(pd.Present) > 1 {
-					disks = append(disks, pd)
-				}
-			}
-		}
+	inv := inventoryOrExit("PD")
+	for _, pd := range inv.PhysicalDevices {
+		disks = append(disks, discoveryDevice{
+			DeviceID:    pd.identity(pdIdentityScheme),
+			DeviceType:  "PD",
+			DeviceAlias: pd.DeviceID,
+			Present:     pd.State,
+			ids:         []string{pd.SerialNumber, pd.WWN, pd.DeviceID},
+		})
 	}
+	maintenanceFlags(disks)
 	data := data{Data: disks}
 
 	//r, _ := json.MarshalIndent(data, "", " ")
@@ -78,70 +78,55 @@ func pdDiscovery() {
 }
 
 func pdStats(pdName string) {
-	deviceType := "PD"
+	disk := map[string]pdInfo{}
 
-	controllers, err := controllersCount()
-	if err != nil {
-		fmt.Printf("Cannot check lspci adaptec controllers: %v", err)
-		os.Exit(1)
-	}
-
-	bin, binErr := getBin("arcconf")
-	if binErr != nil {
-		fmt.Println("Arcconf not found:", err)
-		os.Exit(0)
-	}
-
-	for controller := 1; controller <= controllers; controller++ {
-		args := []string{"getconfig", strconv.Itoa(controller), deviceType, "nologs"}
-		cmd := exec.Command(bin, args...)
-		out, err := cmd.Output()
-		if err != nil {
-			fmt.Printf("Error %v", err)
+	inv := inventoryOrExit("PD")
+	for _, pd := range inv.PhysicalDevices {
+		disk[pd.DeviceID] = pd
+		if len(pd.SerialNumber) > 0 {
+			disk[strings.ToUpper(pd.SerialNumber)] = pd
 		}
-
-		parts := strings.Split(string(out), "Device #")
-		disk := map[string]pdInfo{}
-
-		for _, pdinfo := range parts {
-			pd := pdInfo{}
-
-			for _, pdstat := range strings.Split(pdinfo, "\n") {
-				pd.pdParserInfo(pdstat, controller)
-			}
-			if len(pd.State) > 1 {
-				disk[pd.DeviceID] = pd
-			}
-		}
-
-		if _, ok := disk[pdName]; ok {
-			//r, _ := json.MarshalIndent(disk[pdName], "", " ")
-			r, _ := json.Marshal(disk[pdName])
-			fmt.Print(string(r))
-		} else {
-			fmt.Printf("PD not exist %v", pdName)
-			os.Exit(1)
+		if len(pd.WWN) > 0 {
+			disk[strings.ToUpper(pd.WWN)] = pd
 		}
 	}
-}
 
-func (d *discoveryDevice) pdDiscoveryParser(line string, controller int) error {
-	split := strings.Split(line, " : ")
-	match := strings.ToLower(strings.TrimSpace(split[0]))
-	if match == "reported location" {
-		text := "Controller " + strconv.Itoa(controller) + ", " + strings.TrimSpace(split[1])
-		d.DeviceID = text
+	pd, ok := disk[pdName]
+	if !ok {
+		pd, ok = disk[strings.ToUpper(pdName)]
 	}
-	if match == "state" {
-		state := strings.TrimSpace(split[1])
-		d.Present = state
+	if ok {
+		selected := []pdInfo{pd}
+		// Each field is its own reader: items polling the same drive one
+		// after another must not eat each other's increase.
+		if err := trackCounters(selected, inv.Collected, statsField); err != nil {
+			fmt.Printf("Cannot update error counters state\n - %v", err)
+			os.Exit(1)
+		}
+		selected[0].Maintenance = underMaintenance(activeMaintenance(), "PD", pd.SerialNumber, pd.WWN, pd.DeviceID)
+		//r, _ := json.MarshalIndent(selected[0], "", " ")
+		printStats(selected[0])
+	} else {
+		unsupported(true)
+		notExist("PD", pdName)
 	}
-	d.DeviceType = "PD"
-	return nil
 }
 
 func (pd *pdInfo) pdParserInfo(line string, controller int) error {
 	split := strings.Split(line, " : ")
+	if len(split) < 2 {
+		// "Device is a Hard drive" is the "deviceType" of the JSON backend.
+		text := strings.TrimSpace(line)
+		for _, prefix := range []string{"device is an ", "device is a "} {
+			if strings.HasPrefix(strings.ToLower(text), prefix) {
+				split = []string{"device type", strings.TrimSpace(text[len(prefix):])}
+				break
+			}
+		}
+		if len(split) < 2 {
+			return nil
+		}
+	}
 	match := strings.ToLower(strings.TrimSpace(split[0]))
 	switch match {
 	case "reported location":
@@ -163,6 +148,8 @@ func (pd *pdInfo) pdParserInfo(line string, controller int) error {
 		pd.Firmware = strings.TrimSpace(split[1])
 	case "serial number":
 		pd.SerialNumber = strings.TrimSpace(split[1])
+	case "world-wide name", "wwn":
+		pd.WWN = strings.TrimSpace(split[1])
 	case "reserved size":
 		pd.ReservedSize = strings.TrimSpace(split[1])
 	case "used size":
@@ -171,26 +158,76 @@ func (pd *pdInfo) pdParserInfo(line string, controller int) error {
 		pd.UnusedSize = strings.TrimSpace(split[1])
 	case "total size":
 		pd.TotalSize = strings.TrimSpace(split[1])
+		pd.TotalSizeMB = parseSizeMB(pd.TotalSize)
 	case "write cache":
 		pd.WriteCache = strings.TrimSpace(split[1])
 	case "fru":
 		pd.FRU = strings.TrimSpace(split[1])
 	case "s.m.a.r.t.":
 		pd.Smart = strings.TrimSpace(split[1])
-	case "s.m.a.r.t. warnings":
+	case "s.m.a.r.t. warnings", "smart warnings":
 		res, err := strconv.Atoi(strings.TrimSpace(split[1]))
 		if err != nil {
 			return err
 		}
 		pd.SmartWarnings = res
+	case "hardware errors", "hardware error count":
+		pd.HardwareErrors = parseCounter(split[1])
+	case "medium errors", "medium error count":
+		pd.MediumErrors = parseCounter(split[1])
+	case "parity errors", "parity error count":
+		pd.ParityErrors = parseCounter(split[1])
+	case "link failures", "link failure count":
+		pd.LinkFailures = parseCounter(split[1])
+	case "aborted commands", "aborted command count":
+		pd.AbortedCommands = parseCounter(split[1])
 	case "power state":
 		pd.PowerState = strings.TrimSpace(split[1])
-	case "supported power state":
+	case "supported power state", "supported power states":
 		pd.SupportedPowerStates = strings.TrimSpace(split[1])
 	case "ssd":
 		pd.SSD = strings.TrimSpace(split[1])
 	case "ncq status":
 		pd.NCQ = strings.TrimSpace(split[1])
+	case "hot-spare type":
+		pd.HotSpare = strings.TrimSpace(split[1])
+	case "dedicated spare for":
+		// "Logical Device 0,1" or "0, 1"
+		for _, ld := range strings.Split(strings.TrimPrefix(strings.TrimSpace(split[1]), "Logical Device"), ",") {
+			if ld = strings.TrimSpace(ld); len(ld) > 0 {
+				pd.DedicatedTo = append(pd.DedicatedTo, ld)
+			}
+		}
+	default:
+		pd.Extra = extraField(pd.Extra, match, split)
 	}
 	return nil
 }
+
+// identity returns the drive identifier for the given scheme; drives that do
+// not report a serial number or WWN fall back to their location.
+func (pd *pdInfo) identity(scheme string) string {
+	switch scheme {
+	case "serial":
+		if len(pd.SerialNumber) > 0 {
+			return pd.SerialNumber
+		}
+	case "wwn":
+		if len(pd.WWN) > 0 {
+			return pd.WWN
+		}
+	}
+	return pd.DeviceID
+}
+
+func (pd *pdInfo) spareType() {
+	state := strings.ToLower(pd.State)
+	if !strings.Contains(state, "spare") || len(pd.HotSpare) > 0 {
+		return
+	}
+	if strings.Contains(state, "dedicated") || len(pd.DedicatedTo) > 0 {
+		pd.HotSpare = "Dedicated"
+	} else {
+		pd.HotSpare = "Global"
+	}
+}
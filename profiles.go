@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const versionState = "version.json"
+
+var (
+	cliVersion     = regexp.MustCompile(`Version\s+(\d+)\.(\d+)`)
+	channelDevice  = regexp.MustCompile(`^(\d+),(\d+)`)
+	currentProfile *parseProfile
+)
+
+type parseProfile struct {
+	Name     string
+	MinMajor int
+	MaxMajor int
+	Aliases  map[string]string
+}
+
+type arcconfVersion struct {
+	Path       string            `json:"arcconf path"`
+	ModTime    int64             `json:"arcconf mtime"`
+	Version    string            `json:"arcconf version"`
+	Major      int               `json:"major"`
+	Minor      int               `json:"minor"`
+	Firmware   map[string]string `json:"controller firmware"`
+	Profile    string            `json:"profile"`
+	Supported  bool              `json:"supported"`
+	JSON       *bool             `json:"getconfigjson,omitempty"`
+	Diagnostic string            `json:"diagnostic,omitempty"`
+}
+
+// Profiles are matched on the arcconf major version. Aliases map a key as a
+// given release prints it to the key the parsers switch on.
+var profiles = []parseProfile{
+	{
+		Name:     "legacy",
+		MinMajor: 1,
+		MaxMajor: 2,
+		Aliases: map[string]string{
+			"reported channel,device":      "reported location",
+			"reported channel,device(t:l)": "reported location",
+			"read-cache mode":              "read-cache setting",
+			"write-cache mode":             "write-cache setting",
+			"supported power states":       "supported power state",
+		},
+	},
+	{
+		Name:     "uniform",
+		MinMajor: 2,
+		MaxMajor: 3,
+		Aliases: map[string]string{
+			"supported power states": "supported power state",
+		},
+	},
+	{
+		Name:     "smartpqi",
+		MinMajor: 3,
+		MaxMajor: 5,
+		Aliases: map[string]string{
+			"ncq":                    "ncq status",
+			"controller state":       "controller status",
+			"supported power states": "supported power state",
+		},
+	},
+}
+
+func detectVersion() arcconfVersion {
+	version := arcconfVersion{Firmware: map[string]string{}}
+	bin, err := getBin("arcconf")
+	if err != nil {
+		version.Diagnostic = err.Error()
+		return version
+	}
+	version.Path = bin
+	if info, err := os.Stat(bin); err == nil {
+		version.ModTime = info.ModTime().Unix()
+	}
+
+	cached := arcconfVersion{}
+	if err := loadState(versionState, &cached); err == nil && cached.Path == version.Path && cached.ModTime == version.ModTime &&
+		len(cached.Version) > 0 && cached.Supported && cached.JSON != nil {
+		return cached
+	}
+
+	out, _ := arcconf("version")
+	if match := cliVersion.FindStringSubmatch(string(out)); match == nil {
+		// Older releases only print the version in the banner.
+		banner, _ := arcconf()
+		out = append(out, banner...)
+	}
+
+	controller := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		header := strings.TrimSpace(line)
+		if strings.HasPrefix(header, "Controller #") {
+			controller = strings.TrimPrefix(header, "Controller #")
+			continue
+		}
+		split := strings.Split(line, " : ")
+		if len(split) > 1 && strings.ToLower(strings.TrimSpace(split[0])) == "firmware" && len(controller) > 0 {
+			version.Firmware[controller] = strings.TrimSpace(split[1])
+		}
+	}
+	if match := cliVersion.FindStringSubmatch(string(out)); match != nil {
+		version.Major, _ = strconv.Atoi(match[1])
+		version.Minor, _ = strconv.Atoi(match[2])
+		version.Version = match[1] + "." + match[2]
+	}
+
+	version.Profile = profiles[len(profiles)-1].Name
+	for _, profile := range profiles {
+		if version.Major >= profile.MinMajor && version.Major < profile.MaxMajor {
+			version.Profile = profile.Name
+			version.Supported = true
+		}
+	}
+	// The probe is kept with the version so the auto backend does not run
+	// an extra arcconf call on every collection.
+	_, err = getConfigJSON(1, "AD")
+	available := err == nil
+	version.JSON = &available
+
+	if !version.Supported {
+		version.Diagnostic = fmt.Sprintf("unsupported arcconf version '%v', using %v profile", version.Version, version.Profile)
+	}
+
+	// A failed or unsupported detection is repeated on the next run, it may
+	// have been a transient arcconf error.
+	if len(version.Version) > 0 && version.Supported {
+		saveState(versionState, version)
+	}
+	return version
+}
+
+func profile() *parseProfile {
+	if currentProfile == nil {
+		version := detectVersion()
+		for i := range profiles {
+			if profiles[i].Name == version.Profile {
+				currentProfile = &profiles[i]
+			}
+		}
+	}
+	return currentProfile
+}
+
+// profileLine rewrites a "key : value" line into the dialect the parsers
+// expect for the detected arcconf version.
+func profileLine(line string) string {
+	split := strings.SplitN(line, " : ", 2)
+	if len(split) < 2 {
+		return line
+	}
+	p := profile()
+	if p == nil {
+		return line
+	}
+	match := strings.ToLower(strings.TrimSpace(split[0]))
+	alias, ok := p.Aliases[match]
+	if !ok {
+		return line
+	}
+	value := strings.TrimSpace(split[1])
+	if alias == "reported location" {
+		if channel := channelDevice.FindStringSubmatch(value); channel != nil {
+			value = "Channel " + channel[1] + ", Device " + channel[2]
+		}
+	}
+	return alias + " : " + value
+}
+
+// unsupported prints the version diagnostic when a device came back without
+// the fields every supported arcconf release prints.
+func unsupported(empty bool) {
+	if !empty {
+		return
+	}
+	version := detectVersion()
+	if version.Supported {
+		return
+	}
+	// Like a missing device, with -field the agent marks the item not
+	// supported instead of storing the message as a value.
+	if len(statsField) > 0 {
+		fmt.Printf("%vUnsupported arcconf version: %v", zbxNotSupported, version.Diagnostic)
+		os.Exit(0)
+	}
+	fmt.Printf("Unsupported arcconf version: %v", version.Diagnostic)
+	os.Exit(1)
+}
+
+func versionInfo() {
+	r, _ := json.Marshal(detectVersion())
+	fmt.Print(string(r))
+}
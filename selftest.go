@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type checkResult struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// selftest checks every prerequisite of a collection. Later checks still
+// run when an earlier one fails, so one run shows everything to fix.
+func selftest() (results []checkResult, controllers int) {
+	report := func(check string, passed bool, format string, args ...interface{}) {
+		results = append(results, checkResult{Check: check, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	controllers, err := controllersCount()
+	if err != nil {
+		report("controllers", false, "lspci failed: %v", err)
+	} else {
+		report("controllers", true, "%v found by lspci lines matching %q", controllers, cfg.lspciMatch())
+	}
+
+	bin, err := getBin("arcconf")
+	switch {
+	case err != nil && len(cfg.Arcconf) > 0:
+		report("arcconf", false, "configured arcconf: %v", err)
+	case err != nil:
+		report("arcconf", false, "%v, searched %v", err, strings.Join(cfg.searchPaths(), ", "))
+	default:
+		version := detectVersion()
+		switch {
+		case len(version.Version) < 1:
+			report("arcconf", false, "%v does not report a version: %v", bin, version.Diagnostic)
+		case !version.Supported:
+			report("arcconf", false, "%v: %v", bin, version.Diagnostic)
+		default:
+			report("arcconf", true, "%v version %v, %v profile", bin, version.Version, version.Profile)
+		}
+	}
+
+	if uid := os.Geteuid(); uid == 0 {
+		via := "root"
+		if sudo := os.Getenv("SUDO_USER"); len(sudo) > 0 {
+			via = "sudo by " + sudo
+		}
+		report("permissions", true, "running as %v", via)
+	} else {
+		report("permissions", false, "running as uid %v, arcconf needs root (use sudo)", uid)
+	}
+
+	drivers := []string{}
+	for _, module := range []string{"aacraid", "smartpqi"} {
+		if _, err := os.Stat(filepath.Join("/sys/module", module)); err != nil {
+			continue
+		}
+		version, _ := ioutil.ReadFile(filepath.Join("/sys/module", module, "version"))
+		drivers = append(drivers, strings.TrimSpace(module+" "+strings.TrimSpace(string(version))))
+	}
+	if len(drivers) > 0 {
+		report("kernel driver", true, "%v loaded", strings.Join(drivers, ", "))
+	} else {
+		report("kernel driver", false, "neither aacraid nor smartpqi is loaded")
+	}
+
+	for controller := 1; controller <= controllers; controller++ {
+		check := fmt.Sprintf("parse controller %v", controller)
+		ad, err := collectAD(controller)
+		if err != nil {
+			report(check, false, "arcconf getconfig %v AD failed: %v", controller, err)
+			continue
+		}
+		if len(ad.ControllerModel) < 1 {
+			report(check, false, "no controller model in arcconf getconfig %v AD", controller)
+			continue
+		}
+		lds, err := collectLDs(controller)
+		if err != nil {
+			report(check, false, "arcconf getconfig %v LD failed: %v", controller, err)
+			continue
+		}
+		pds, err := collectPDs(controller)
+		if err != nil {
+			report(check, false, "arcconf getconfig %v PD failed: %v", controller, err)
+			continue
+		}
+		report(check, true, "%v with %v logical and %v physical devices", ad.ControllerModel, len(lds), len(pds))
+	}
+
+	probe := filepath.Join(stateDir, ".selftest")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		report("cache directory", false, "%v", err)
+	} else if err := ioutil.WriteFile(probe, []byte("ok"), 0644); err != nil {
+		report("cache directory", false, "%v", err)
+	} else {
+		os.Remove(probe)
+		report("cache directory", true, "%v is writable", stateDir)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(stateDir, "*.json"))
+	broken := []string{}
+	for _, file := range files {
+		var v interface{}
+		raw, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(raw, &v)
+		}
+		if err != nil {
+			broken = append(broken, fmt.Sprintf("%v: %v", filepath.Base(file), err))
+		}
+	}
+	if len(broken) > 0 {
+		report("state store", false, "%v", strings.Join(broken, "; "))
+	} else {
+		report("state store", true, "%v state files readable", len(files))
+	}
+	return results, controllers
+}
+
+func selftestReport(format string) {
+	results, _ := selftest()
+	failed := false
+	for _, result := range results {
+		failed = failed || !result.Passed
+	}
+
+	if format == "json" {
+		r, _ := json.Marshal(results)
+		fmt.Print(string(r))
+	} else {
+		for _, result := range results {
+			status := "PASS"
+			if !result.Passed {
+				status = "FAIL"
+			}
+			fmt.Printf("%v %v: %v\n", status, result.Check, result.Detail)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type snapshot struct {
+	Host      string            `json:"host"`
+	Taken     int64             `json:"taken"`
+	Inventory inventory         `json:"inventory"`
+	Raw       map[string]string `json:"raw"`
+}
+
+type difference struct {
+	Change     string `json:"change"`
+	DeviceType string `json:"device type"`
+	Device     string `json:"device"`
+	Field      string `json:"field,omitempty"`
+	Old        string `json:"old,omitempty"`
+	New        string `json:"new,omitempty"`
+}
+
+// Fields compared by diff, by their JSON names.
+var (
+	adDiffFields = []string{"controller model", "controller serial number", "controller status", "bios", "firmware", "driver", "status", "installed memory"}
+	ldDiffFields = []string{"logical device name", "raid level", "size", "stripe-unit size", "status of logical device",
+		"read-cache setting", "read-cache status", "write-cache setting", "write-cache status", "protected by hot-spare", "members"}
+	pdDiffFields = []string{"device id", "state", "firmware", "total size", "hot spare", "dedicated to"}
+)
+
+// configCommands lists the arcconf calls the configuration is parsed from.
+func configCommands(controllers int) [][]string {
+	commands := [][]string{}
+	for controller := 1; controller <= controllers; controller++ {
+		id := strconv.Itoa(controller)
+		for _, deviceType := range []string{"AD", "LD", "PD", "CN"} {
+			commands = append(commands, append([]string{"getconfig", id, deviceType}, cfg.getconfigArgs()...))
+		}
+		commands = append(commands, []string{"getstatus", id})
+	}
+	return commands
+}
+
+// rawOutputs runs the commands and keeps their output by command line. A
+// failing command is kept with its error so the record stays complete, the
+// errors are returned by command line as well.
+func rawOutputs(commands [][]string) (map[string]string, map[string]error) {
+	raw := map[string]string{}
+	failed := map[string]error{}
+	for _, args := range commands {
+		command := "arcconf " + strings.Join(args, " ")
+		out, err := arcconf(args...)
+		if err != nil {
+			out = append(out, fmt.Sprintf("\n[error: %v]\n", err)...)
+			failed[command] = err
+		}
+		raw[command] = string(out)
+	}
+	return raw, failed
+}
+
+// snapshotInventory runs the configuration commands once and parses the
+// devices out of the captured output, so the inventory and the raw record
+// of a snapshot always agree.
+func snapshotInventory() (inventory, map[string]string, error) {
+	inv := inventory{Collected: time.Now().Unix()}
+	controllers, err := controllersCount()
+	if err != nil {
+		return inv, nil, err
+	}
+
+	raw, failed := rawOutputs(configCommands(controllers))
+	for controller := 1; controller <= controllers; controller++ {
+		output := func(deviceType string) (string, error) {
+			args := append([]string{"getconfig", strconv.Itoa(controller), deviceType}, cfg.getconfigArgs()...)
+			command := "arcconf " + strings.Join(args, " ")
+			if err := failed[command]; err != nil {
+				return "", fmt.Errorf("%v: %v", command, err)
+			}
+			return raw[command], nil
+		}
+		ad, err := output("AD")
+		if err != nil {
+			return inv, raw, err
+		}
+		lds, err := output("LD")
+		if err != nil {
+			return inv, raw, err
+		}
+		pds, err := output("PD")
+		if err != nil {
+			return inv, raw, err
+		}
+		inv.Controllers = append(inv.Controllers, parseAD(ad, controller))
+		inv.LogicalDevices = append(inv.LogicalDevices, parseLDs(lds, controller)...)
+		inv.PhysicalDevices = append(inv.PhysicalDevices, parsePDs(pds, controller)...)
+	}
+	return inv, raw, nil
+}
+
+func takeSnapshot(path string) {
+	inv, raw, err := snapshotInventory()
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+
+	host, _ := os.Hostname()
+	snap := snapshot{
+		Host:      host,
+		Taken:     inv.Collected,
+		Inventory: inv,
+		Raw:       raw,
+	}
+	if len(path) < 1 {
+		stamp := time.Unix(snap.Taken, 0).UTC().Format("20060102-150405")
+		path = filepath.Join(stateDir, "snapshots", "snapshot-"+stamp+".json")
+	} else if err := dropPrivileges(); err != nil {
+		fmt.Printf("Cannot drop privileges to write %v\n - %v", path, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Printf("Cannot save snapshot\n - %v", err)
+		os.Exit(1)
+	}
+	r, _ := json.MarshalIndent(snap, "", "  ")
+	if err := ioutil.WriteFile(path, r, 0644); err != nil {
+		fmt.Printf("Cannot save snapshot\n - %v", err)
+		os.Exit(1)
+	}
+	fmt.Println(path)
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	snap := snapshot{}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	return snap, json.Unmarshal(raw, &snap)
+}
+
+// diffInventory compares the devices of two inventories. Drives are matched
+// by serial number, so a drive in another slot shows as a changed device id.
+func diffInventory(old inventory, new inventory) []difference {
+	diffs := []difference{}
+	compare := func(deviceType string, fields []string, before map[string]interface{}, after map[string]interface{}) {
+		names := []string{}
+		for name := range before {
+			names = append(names, name)
+		}
+		for name := range after {
+			if _, ok := before[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			a, inOld := before[name]
+			b, inNew := after[name]
+			switch {
+			case !inNew:
+				diffs = append(diffs, difference{Change: "removed", DeviceType: deviceType, Device: name})
+			case !inOld:
+				diffs = append(diffs, difference{Change: "added", DeviceType: deviceType, Device: name})
+			default:
+				oldFields, newFields := diffFields(a), diffFields(b)
+				for _, field := range fields {
+					if oldFields[field] != newFields[field] {
+						diffs = append(diffs, difference{
+							Change:     "changed",
+							DeviceType: deviceType,
+							Device:     name,
+							Field:      field,
+							Old:        oldFields[field],
+							New:        newFields[field],
+						})
+					}
+				}
+			}
+		}
+	}
+
+	byController := func(inv inventory) map[string]interface{} {
+		devices := map[string]interface{}{}
+		for _, ad := range inv.Controllers {
+			devices[strconv.Itoa(ad.Controller)] = ad
+		}
+		return devices
+	}
+	byUID := func(inv inventory) map[string]interface{} {
+		devices := map[string]interface{}{}
+		for _, ld := range inv.LogicalDevices {
+			devices[ld.UniqueIdentifier] = ld
+		}
+		return devices
+	}
+	bySerial := func(inv inventory) map[string]interface{} {
+		devices := map[string]interface{}{}
+		for _, pd := range inv.PhysicalDevices {
+			devices[pd.identity("serial")] = pd
+		}
+		return devices
+	}
+	compare("AD", adDiffFields, byController(old), byController(new))
+	compare("LD", ldDiffFields, byUID(old), byUID(new))
+	compare("PD", pdDiffFields, bySerial(old), bySerial(new))
+	return diffs
+}
+
+func diffFields(device interface{}) map[string]string {
+	r, _ := json.Marshal(device)
+	doc := map[string]interface{}{}
+	json.Unmarshal(r, &doc)
+	fields := map[string]string{}
+	for key, value := range doc {
+		fields[key] = scalar(value)
+	}
+	return fields
+}
+
+// diffSnapshots compares a snapshot with a second one or, without it, with
+// the live system. Like diff(1) it exits with 1 when something changed.
+func diffSnapshots(paths []string, format string) {
+	if len(paths) < 1 || len(paths) > 2 {
+		fmt.Println("diff <snapshot> [snapshot] - one or two snapshot files required")
+		os.Exit(2)
+	}
+	var after inventory
+	var err error
+	// The live side is parsed the way snapshots are, a backend difference
+	// must not show up as a changed device.
+	if len(paths) < 2 {
+		if after, _, err = snapshotInventory(); err != nil {
+			fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+			os.Exit(2)
+		}
+	}
+	// The snapshot files are read with the rights of the user who named them.
+	if err := dropPrivileges(); err != nil {
+		fmt.Printf("Cannot drop privileges to read snapshots\n - %v", err)
+		os.Exit(2)
+	}
+	before, err := readSnapshot(paths[0])
+	if err != nil {
+		fmt.Printf("Cannot read snapshot\n - %v", err)
+		os.Exit(2)
+	}
+	if len(paths) == 2 {
+		snap, err := readSnapshot(paths[1])
+		if err != nil {
+			fmt.Printf("Cannot read snapshot\n - %v", err)
+			os.Exit(2)
+		}
+		after = snap.Inventory
+	}
+
+	diffs := diffInventory(before.Inventory, after)
+	if format == "json" {
+		r, _ := json.Marshal(diffs)
+		fmt.Print(string(r))
+	} else {
+		for _, d := range diffs {
+			switch d.Change {
+			case "added":
+				fmt.Printf("+ %v %v\n", d.DeviceType, d.Device)
+			case "removed":
+				fmt.Printf("- %v %v\n", d.DeviceType, d.Device)
+			default:
+				fmt.Printf("~ %v %v %v: %v -> %v\n", d.DeviceType, d.Device, d.Field, d.Old, d.New)
+			}
+		}
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type spareInfo struct {
+	Controller    int      `json:"controller"`
+	DeviceID      string   `json:"device id"`
+	SerialNumber  string   `json:"serial number"`
+	Type          string   `json:"type"`
+	AssignedLDs   []string `json:"assigned logical devices"`
+	SizeMB        int      `json:"size mb"`
+	SSD           string   `json:"ssd"`
+	TransferSpeed string   `json:"transfer speed"`
+	Problems      []string `json:"problems"`
+}
+
+type ldCoverage struct {
+	Controller          int      `json:"controller"`
+	Number              string   `json:"logical device number"`
+	LdName              string   `json:"logical device name"`
+	UniqueIdentifier    string   `json:"unique identifier"`
+	RaidLevel           string   `json:"raid level"`
+	ProtectedByHotSpare string   `json:"protected by hot-spare"`
+	LargestMemberMB     int      `json:"largest member mb"`
+	Spares              []string `json:"usable spares"`
+	Problems            []string `json:"problems"`
+}
+
+type spareReport struct {
+	Spares         []spareInfo  `json:"spares"`
+	LogicalDevices []ldCoverage `json:"logical devices"`
+}
+
+func sparesReport() {
+	inv, err := loadInventory()
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+
+	r, _ := json.Marshal(spareCoverage(inv))
+	fmt.Print(string(r))
+}
+
+func spareCoverage(inv inventory) spareReport {
+	report := spareReport{Spares: []spareInfo{}, LogicalDevices: []ldCoverage{}}
+	spares := map[string]*spareInfo{}
+	serials := map[string]pdInfo{}
+
+	for _, pd := range inv.PhysicalDevices {
+		serials[pd.SerialNumber] = pd
+		if len(pd.HotSpare) < 1 {
+			continue
+		}
+		spare := &spareInfo{
+			Controller:    pd.Controller,
+			DeviceID:      pd.DeviceID,
+			SerialNumber:  pd.SerialNumber,
+			Type:          pd.HotSpare,
+			AssignedLDs:   []string{},
+			SizeMB:        parseSizeMB(pd.TotalSize),
+			SSD:           pd.SSD,
+			TransferSpeed: pd.TransferSpeed,
+			Problems:      []string{},
+		}
+		if len(pd.DedicatedTo) > 0 {
+			spare.AssignedLDs = pd.DedicatedTo
+		}
+		spares[pd.DeviceID] = spare
+	}
+
+	for _, ld := range inv.LogicalDevices {
+		coverage := ldCoverage{
+			Controller:          ld.Controller,
+			Number:              ld.Number,
+			LdName:              ld.LdName,
+			UniqueIdentifier:    ld.UniqueIdentifier,
+			RaidLevel:           ld.RaidLevel,
+			ProtectedByHotSpare: ld.ProtectedByHotSpare,
+			Spares:              []string{},
+			Problems:            []string{},
+		}
+
+		memberSSD := ""
+		memberSpeed := 0.0
+		for _, serial := range ld.Members {
+			member, ok := serials[serial]
+			if !ok {
+				continue
+			}
+			if size := parseSizeMB(member.TotalSize); size > coverage.LargestMemberMB {
+				coverage.LargestMemberMB = size
+			}
+			if speed := parseSpeedGbps(member.TransferSpeed); speed > memberSpeed {
+				memberSpeed = speed
+			}
+			memberSSD = member.SSD
+		}
+
+		if !redundantRaid(ld.RaidLevel) {
+			report.LogicalDevices = append(report.LogicalDevices, coverage)
+			continue
+		}
+
+		candidates, large := 0, 0
+		for _, pd := range inv.PhysicalDevices {
+			spare, ok := spares[pd.DeviceID]
+			if !ok || pd.Controller != ld.Controller || !spareCovers(pd, ld.Number) {
+				continue
+			}
+			candidates++
+			usable := true
+			name := "LD " + ld.Number
+			if spare.SizeMB < coverage.LargestMemberMB {
+				spare.Problems = append(spare.Problems, "smaller than members of "+name)
+				usable = false
+			} else {
+				large++
+			}
+			if len(memberSSD) > 0 && !strings.EqualFold(spare.SSD, memberSSD) {
+				spare.Problems = append(spare.Problems, "media type differs from members of "+name)
+				usable = false
+			}
+			if speed := parseSpeedGbps(spare.TransferSpeed); speed > 0 && speed < memberSpeed {
+				spare.Problems = append(spare.Problems, "slower than members of "+name)
+				usable = false
+			}
+			if usable {
+				coverage.Spares = append(coverage.Spares, spare.DeviceID)
+			}
+		}
+
+		switch {
+		case candidates == 0:
+			coverage.Problems = append(coverage.Problems, "no hot spare available")
+		case large == 0:
+			coverage.Problems = append(coverage.Problems, "members larger than every available spare")
+		case len(coverage.Spares) == 0:
+			coverage.Problems = append(coverage.Problems, "no suitable hot spare")
+		}
+		report.LogicalDevices = append(report.LogicalDevices, coverage)
+	}
+
+	for _, pd := range inv.PhysicalDevices {
+		if spare, ok := spares[pd.DeviceID]; ok {
+			report.Spares = append(report.Spares, *spare)
+		}
+	}
+	return report
+}
+
+func spareCovers(pd pdInfo, ldNumber string) bool {
+	if pd.HotSpare != "Dedicated" {
+		return true
+	}
+	for _, ld := range pd.DedicatedTo {
+		if ld == ldNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func redundantRaid(level string) bool {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "", "0", "simple_volume", "spanned_volume", "raid_volume":
+		return false
+	}
+	return true
+}
+
+// parseSizeMB converts arcconf sizes such as "953869 MB" or "538264 KB".
+func parseSizeMB(size string) int {
+	fields := strings.Fields(size)
+	if len(fields) < 1 {
+		return 0
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	unit := ""
+	if len(fields) > 1 {
+		unit = strings.ToUpper(fields[1])
+	}
+	switch unit {
+	case "KB":
+		value /= 1024
+	case "GB":
+		value *= 1024
+	case "TB":
+		value *= 1024 * 1024
+	}
+	return int(value)
+}
+
+// parseSpeedGbps reads the link speed out of strings like "SATA 6.0 Gb/s".
+func parseSpeedGbps(speed string) float64 {
+	for _, field := range strings.Fields(speed) {
+		if value, err := strconv.ParseFloat(field, 64); err == nil {
+			return value
+		}
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// statsField selects a single value of the stats object, either by key or
+// as a JSON pointer such as "/delta/medium errors".
+var statsField = ""
+
+// zbxNotSupported makes the Zabbix agent mark the item as not supported and
+// show the message after the NUL byte.
+const zbxNotSupported = "ZBX_NOTSUPPORTED\x00"
+
+func printStats(v interface{}) {
+	r, _ := json.Marshal(v)
+	if len(statsField) < 1 {
+		fmt.Print(string(r))
+		return
+	}
+
+	var doc interface{}
+	json.Unmarshal(r, &doc)
+	value, ok := lookupField(doc, statsField)
+	if !ok {
+		fmt.Printf("%vUnknown field: %v", zbxNotSupported, statsField)
+		return
+	}
+	fmt.Print(scalar(value))
+}
+
+// notExist reports a device that is not there. With -field set the agent
+// marks the item not supported instead of storing the message as a value.
+func notExist(deviceType string, name string) {
+	if len(statsField) > 0 {
+		fmt.Printf("%v%v not exist %v", zbxNotSupported, deviceType, name)
+		os.Exit(0)
+	}
+	fmt.Printf("%v not exist %v", deviceType, name)
+	os.Exit(1)
+}
+
+func lookupField(doc interface{}, field string) (interface{}, bool) {
+	if strings.HasPrefix(field, "/") {
+		return jsonPointer(doc, field)
+	}
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if value, ok := object[field]; ok {
+		return value, true
+	}
+	// "serial_number" and "SerialNumber" both find "serial number", keys
+	// arcconf reported but the parser does not know are found in "extra".
+	for _, values := range []interface{}{object, object["extra"]} {
+		values, ok := values.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range values {
+			if compactKey(key) == compactKey(field) {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// jsonPointer resolves an RFC 6901 pointer.
+func jsonPointer(doc interface{}, pointer string) (interface{}, bool) {
+	value := doc
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		token = unescape.Replace(token)
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			value = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// scalar prints booleans as 1/0 for Zabbix triggers and keeps lists and
+// objects as JSON.
+func scalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	r, _ := json.Marshal(value)
+	return string(r)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const tasksState = "tasks.json"
+
+type taskInfo struct {
+	TaskID               string `json:"task id"`
+	Controller           int    `json:"controller"`
+	Number               string `json:"number"`
+	Type                 string `json:"type"`
+	TargetType           string `json:"target type"`
+	Target               string `json:"target"`
+	State                string `json:"state"`
+	PercentComplete      int    `json:"percent complete"`
+	Priority             string `json:"priority"`
+	ETA                  int64  `json:"eta seconds"`
+	SecondsSinceProgress int64  `json:"seconds since progress"`
+}
+
+type taskSample struct {
+	FirstPercent int   `json:"first percent"`
+	FirstTime    int64 `json:"first time"`
+	Percent      int   `json:"percent"`
+	ChangeTime   int64 `json:"change time"`
+}
+
+// getTasks returns the tasks from a running daemon, which tracks their
+// progress on every poll, or collects them.
+func getTasks() ([]taskInfo, error) {
+	if reply, err := askDaemon("tasks"); err == nil {
+		return reply.Tasks, nil
+	}
+	return collectTasks()
+}
+
+func collectTasks() ([]taskInfo, error) {
+	controllers, err := controllersCount()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := []taskInfo{}
+	for controller := 1; controller <= controllers; controller++ {
+		out, err := arcconf("getstatus", strconv.Itoa(controller))
+		if err != nil {
+			return nil, err
+		}
+
+		var task *taskInfo
+		for _, line := range strings.Split(string(out), "\n") {
+			header := strings.TrimSpace(line)
+			if strings.HasSuffix(header, "Task:") {
+				if task != nil && len(task.Number) > 0 {
+					tasks = append(tasks, *task)
+				}
+				task = &taskInfo{Controller: controller, TargetType: "LD"}
+				if strings.HasPrefix(strings.ToLower(header), "physical") {
+					task.TargetType = "PD"
+				}
+				continue
+			}
+			if task != nil {
+				task.taskParserInfo(line)
+			}
+		}
+		if task != nil && len(task.Number) > 0 {
+			tasks = append(tasks, *task)
+		}
+	}
+
+	for i := range tasks {
+		tasks[i].TaskID = "Controller " + strconv.Itoa(tasks[i].Controller) + ", Task " + tasks[i].Number
+	}
+	if err := trackTaskProgress(tasks, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// trackTaskProgress compares every task with the samples kept from earlier
+// runs and fills in the ETA and the time since the percentage last moved.
+func trackTaskProgress(tasks []taskInfo, now int64) error {
+	unlock, err := lockState(tasksState)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	samples := map[string]taskSample{}
+	if err := loadState(tasksState, &samples); err != nil {
+		return err
+	}
+
+	current := map[string]taskSample{}
+	for i, task := range tasks {
+		sample, ok := samples[task.TaskID]
+		if !ok || task.PercentComplete < sample.Percent {
+			sample = taskSample{
+				FirstPercent: task.PercentComplete,
+				FirstTime:    now,
+				Percent:      task.PercentComplete,
+				ChangeTime:   now,
+			}
+		}
+		if task.PercentComplete != sample.Percent {
+			sample.Percent = task.PercentComplete
+			sample.ChangeTime = now
+		}
+
+		tasks[i].ETA = -1
+		progress := sample.Percent - sample.FirstPercent
+		if elapsed := now - sample.FirstTime; progress > 0 && elapsed > 0 {
+			tasks[i].ETA = int64(100-sample.Percent) * elapsed / int64(progress)
+		}
+		tasks[i].SecondsSinceProgress = now - sample.ChangeTime
+		current[task.TaskID] = sample
+	}
+	return saveState(tasksState, current)
+}
+
+func taskDiscovery() {
+	tasks, err := getTasks()
+	if err != nil {
+		fmt.Printf("Cannot get arcconf task status\n - %v", err)
+		os.Exit(1)
+	}
+
+	devices := []discoveryDevice{}
+	for _, task := range tasks {
+		devices = append(devices, discoveryDevice{
+			DeviceID:    task.TaskID,
+			DeviceType:  "TASK",
+			DeviceAlias: task.Type + " " + task.TargetType + " " + task.Target,
+			Present:     task.State,
+		})
+	}
+	data := data{Data: devices}
+
+	r, _ := json.Marshal(data)
+	fmt.Print(string(r))
+}
+
+func taskStats(taskName string) {
+	tasks, err := getTasks()
+	if err != nil {
+		fmt.Printf("Cannot get arcconf task status\n - %v", err)
+		os.Exit(1)
+	}
+
+	for _, task := range tasks {
+		if task.TaskID == taskName {
+			printStats(task)
+			return
+		}
+	}
+	notExist("TASK", taskName)
+}
+
+func (t *taskInfo) taskParserInfo(line string) error {
+	split := strings.Split(line, " : ")
+	if len(split) < 2 {
+		return nil
+	}
+	match := strings.ToLower(strings.TrimSpace(split[0]))
+	switch match {
+	case "task id":
+		t.Number = strings.TrimSpace(split[1])
+	case "logical device", "channel,device", "physical device":
+		t.Target = strings.TrimSpace(split[1])
+	case "current operation":
+		t.Type = strings.TrimSpace(split[1])
+	case "status":
+		t.State = strings.TrimSpace(split[1])
+	case "priority":
+		t.Priority = strings.TrimSpace(split[1])
+	case "percentage complete":
+		percent, err := strconv.Atoi(strings.TrimSpace(split[1]))
+		if err != nil {
+			return err
+		}
+		t.PercentComplete = percent
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	transitionsState   = "transitions.json"
+	transitionsPending = 500
+)
+
+type stateStore struct {
+	Values  map[string]stateValue `json:"values"`
+	Pending []transition          `json:"pending"`
+}
+
+type stateValue struct {
+	Value string `json:"value"`
+	Since int64  `json:"since"`
+}
+
+type transition struct {
+	Time       int64  `json:"time"`
+	DeviceType string `json:"device type"`
+	Device     string `json:"device"`
+	Field      string `json:"field"`
+	Old        string `json:"old"`
+	New        string `json:"new"`
+}
+
+type trackedState struct {
+	DeviceType string
+	Device     string
+	Field      string
+	Value      string
+}
+
+func trackedStates(inv inventory) []trackedState {
+	states := []trackedState{}
+	for _, ad := range inv.Controllers {
+		id := strconv.Itoa(ad.Controller)
+		states = append(states,
+			trackedState{"AD", id, "controller status", ad.ControllerStatus},
+			trackedState{"AD", id, "battery status", ad.Status},
+			trackedState{"AD", id, "temperature status", temperatureStatus(ad)},
+		)
+	}
+	for _, ld := range inv.LogicalDevices {
+		states = append(states,
+			trackedState{"LD", ld.UniqueIdentifier, "status of logical device", ld.StatusLD},
+			trackedState{"LD", ld.UniqueIdentifier, "write-cache status", ld.WriteCacheStatus},
+		)
+	}
+	for _, pd := range inv.PhysicalDevices {
+		states = append(states, trackedState{"PD", pd.identity("serial"), "state", pd.State})
+	}
+	return states
+}
+
+// temperatureStatus turns the controller temperature into a state so crossing
+// the health threshold is seen as a transition.
+func temperatureStatus(ad adInfo) string {
+	rule := cfg.Health.rule("temperature", strconv.Itoa(ad.Controller))
+	if parseTemperature(ad.Temperature) > rule.limit() {
+		return "critical"
+	}
+	return "normal"
+}
+
+// updateTransitions records every tracked value that changed since the last
+// collection. Transitions stay pending until the transitions command reports
+// them, so flaps between two Zabbix polls are not lost.
+func updateTransitions(inv inventory, now int64) ([]transition, error) {
+	unlock, err := lockState(transitionsState)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	store := stateStore{}
+	if err := loadState(transitionsState, &store); err != nil {
+		return nil, err
+	}
+	if store.Values == nil {
+		store.Values = map[string]stateValue{}
+	}
+
+	changes := []transition{}
+	for _, state := range trackedStates(inv) {
+		key := state.DeviceType + "|" + state.Device + "|" + state.Field
+		last, ok := store.Values[key]
+		if ok && last.Value == state.Value {
+			continue
+		}
+		if ok {
+			changes = append(changes, transition{
+				Time:       now,
+				DeviceType: state.DeviceType,
+				Device:     state.Device,
+				Field:      state.Field,
+				Old:        last.Value,
+				New:        state.Value,
+			})
+		}
+		store.Values[key] = stateValue{Value: state.Value, Since: now}
+	}
+
+	store.Pending = append(store.Pending, changes...)
+	if len(store.Pending) > transitionsPending {
+		store.Pending = store.Pending[len(store.Pending)-transitionsPending:]
+	}
+	return changes, saveState(transitionsState, store)
+}
+
+// notify hands the changes of one collection to the configured receivers.
+// Hooks and webhooks are only queued here, the daemon or the notify command
+// delivers them so a dead receiver or a slow program does not hold up a
+// collection. A failing receiver does not keep the others from running, the
+// first error is returned.
+func notify(inv inventory, changes []transition) error {
+	for _, err := range []error{
+		queueHooks(inv, changes),
+		logTransitions(inv, changes),
+		queueWebhooks(inv, changes),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transitionsReport(format string) {
+	inv, err := loadInventory()
+	if err != nil {
+		fmt.Printf("Cannot collect adaptec inventory\n - %v", err)
+		os.Exit(1)
+	}
+
+	now := time.Now().Unix()
+	changes, err := updateTransitions(inv, now)
+	if err != nil {
+		fmt.Printf("Cannot update device states\n - %v", err)
+		os.Exit(1)
+	}
+	// Queued notifications are delivered by the daemon or the notify
+	// command, which also shows what is still waiting. The changes stay
+	// pending when they cannot be queued and are reported next time.
+	if err := notify(inv, changes); err != nil {
+		fmt.Printf("Cannot queue notifications\n - %v", err)
+		os.Exit(1)
+	}
+
+	unlock, err := lockState(transitionsState)
+	if err != nil {
+		fmt.Printf("Cannot lock device states\n - %v", err)
+		os.Exit(1)
+	}
+	defer unlock()
+	store := stateStore{}
+	if err := loadState(transitionsState, &store); err != nil {
+		fmt.Printf("Cannot read device states\n - %v", err)
+		os.Exit(1)
+	}
+	pending := store.Pending
+	store.Pending = nil
+	if err := saveState(transitionsState, store); err != nil {
+		fmt.Printf("Cannot save device states\n - %v", err)
+		os.Exit(1)
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Time < pending[j].Time })
+	if format == "text" {
+		for _, t := range pending {
+			stamp := time.Unix(t.Time, 0).UTC().Format("2006-01-02 15:04:05")
+			fmt.Printf("%v %v %v %v: %v -> %v\n", stamp, t.DeviceType, t.Device, t.Field, t.Old, t.New)
+		}
+		return
+	}
+	if pending == nil {
+		pending = []transition{}
+	}
+	r, _ := json.Marshal(pending)
+	fmt.Print(string(r))
+}
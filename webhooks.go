@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outboxState = "outbox.json"
+	// Delivered event IDs are remembered for a day so a transition queued
+	// again, e.g. by a retried notify run, is not posted twice.
+	outboxSentKeep = 24 * 60 * 60
+	// webhookRetry is the first backoff and how often the daemon looks for
+	// due entries.
+	webhookRetry = 30
+)
+
+type webhookConfig struct {
+	URLs     []string `json:"urls"`
+	Attempts int      `json:"attempts"`
+	Timeout  int      `json:"timeout"`
+}
+
+type outbox struct {
+	Queue []outboxEntry    `json:"queue"`
+	Sent  map[string]int64 `json:"sent"`
+}
+
+// outboxEntry is a webhook post to URL, or a hook run of Program for Event.
+type outboxEntry struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url,omitempty"`
+	Program     string          `json:"program,omitempty"`
+	Event       string          `json:"event,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"next attempt"`
+	LastError   string          `json:"last error,omitempty"`
+}
+
+type webhookPayload struct {
+	ID         string            `json:"id"`
+	Host       string            `json:"host"`
+	Time       int64             `json:"time"`
+	DeviceType string            `json:"device type"`
+	Device     string            `json:"device"`
+	Name       string            `json:"name,omitempty"`
+	Identity   *pdIdentity       `json:"identity,omitempty"`
+	Controller webhookController `json:"controller"`
+	Field      string            `json:"field"`
+	Old        string            `json:"old"`
+	New        string            `json:"new"`
+}
+
+type webhookController struct {
+	Number       int    `json:"number"`
+	Model        string `json:"model"`
+	SerialNumber string `json:"serial number"`
+}
+
+func (e outboxEntry) key() string {
+	if len(e.Program) > 0 {
+		return e.ID + "|" + e.Program
+	}
+	return e.ID + "|" + e.URL
+}
+
+func (w webhookConfig) attempts() int {
+	if w.Attempts < 1 {
+		return 10
+	}
+	return w.Attempts
+}
+
+func (w webhookConfig) timeout() time.Duration {
+	if w.Timeout < 1 {
+		return 10 * time.Second
+	}
+	return time.Duration(w.Timeout) * time.Second
+}
+
+// eventID names one occurrence of a change. The transitions lock already
+// keeps the daemon and the CLI from detecting it twice; the time tells a
+// recurrence of the same change apart, it has to be posted again.
+func eventID(t transition) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%v|%v|%v|%v|%v|%v", t.DeviceType, t.Device, t.Field, t.Old, t.New, t.Time)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func webhookEvent(inv inventory, t transition) webhookPayload {
+	host, _ := os.Hostname()
+	payload := webhookPayload{
+		ID:         eventID(t),
+		Host:       host,
+		Time:       t.Time,
+		DeviceType: t.DeviceType,
+		Device:     t.Device,
+		Field:      t.Field,
+		Old:        t.Old,
+		New:        t.New,
+	}
+
+	controller := 0
+	switch t.DeviceType {
+	case "AD":
+		controller, _ = strconv.Atoi(t.Device)
+	case "LD":
+		for _, ld := range inv.LogicalDevices {
+			if ld.UniqueIdentifier == t.Device {
+				controller = ld.Controller
+				payload.Name = ld.LdName
+			}
+		}
+	case "PD":
+		for _, pd := range inv.PhysicalDevices {
+			if pd.identity("serial") == t.Device {
+				controller = pd.Controller
+				identity := pd.Identity
+				payload.Identity = &identity
+			}
+		}
+	}
+	for _, ad := range inv.Controllers {
+		if ad.Controller == controller {
+			payload.Controller = webhookController{
+				Number:       ad.Controller,
+				Model:        ad.ControllerModel,
+				SerialNumber: ad.ControllerSerialNumber,
+			}
+		}
+	}
+	return payload
+}
+
+// queueWebhooks puts one outbox entry per configured URL for every change.
+// Entries are written before any delivery so they survive a restart.
+func queueWebhooks(inv inventory, changes []transition) error {
+	if len(cfg.Webhooks.URLs) < 1 || len(changes) < 1 {
+		return nil
+	}
+	payloads := []webhookPayload{}
+	for _, t := range changes {
+		payloads = append(payloads, webhookEvent(inv, t))
+	}
+	entries, err := webhookEntries(payloads)
+	if err != nil {
+		return err
+	}
+	return enqueue(entries)
+}
+
+func webhookEntries(payloads []webhookPayload) ([]outboxEntry, error) {
+	entries := []outboxEntry{}
+	for _, payload := range payloads {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		for _, url := range cfg.Webhooks.URLs {
+			entries = append(entries, outboxEntry{ID: payload.ID, URL: url, Payload: raw})
+		}
+	}
+	return entries, nil
+}
+
+// enqueue adds the entries not already queued or delivered to the outbox.
+func enqueue(entries []outboxEntry) error {
+	unlock, err := lockState(outboxState)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	box := outbox{}
+	if err := loadState(outboxState, &box); err != nil {
+		return err
+	}
+	queued := map[string]bool{}
+	for _, entry := range box.Queue {
+		queued[entry.key()] = true
+	}
+
+	for _, entry := range entries {
+		key := entry.key()
+		if _, sent := box.Sent[key]; sent || queued[key] {
+			continue
+		}
+		queued[key] = true
+		box.Queue = append(box.Queue, entry)
+	}
+	return saveState(outboxState, box)
+}
+
+// deliverOutbox posts every due webhook and runs every due hook. Failed
+// webhooks are retried with an exponential backoff until the configured
+// number of attempts, hooks run once. The outbox is not locked meanwhile,
+// due entries are claimed first so a second deliverer skips them. Entries
+// given up on are returned as an error after the outbox is saved.
+func deliverOutbox(now int64) error {
+	due, err := claimOutbox(now)
+	if err != nil || len(due) < 1 {
+		return err
+	}
+
+	client := &http.Client{Timeout: cfg.Webhooks.timeout()}
+	results := map[string]error{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, cfg.Hooks.concurrency())
+	for _, entry := range due {
+		if len(entry.Program) < 1 {
+			results[entry.key()] = post(client, entry)
+			continue
+		}
+		wg.Add(1)
+		go func(entry outboxEntry) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			err := runHook(entry)
+			mu.Lock()
+			results[entry.key()] = err
+			mu.Unlock()
+		}(entry)
+	}
+	wg.Wait()
+
+	unlock, err := lockState(outboxState)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	box := outbox{}
+	if err := loadState(outboxState, &box); err != nil {
+		return err
+	}
+	if box.Sent == nil {
+		box.Sent = map[string]int64{}
+	}
+	queue := []outboxEntry{}
+	failed := []string{}
+	for _, entry := range box.Queue {
+		key := entry.key()
+		err, posted := results[key]
+		switch {
+		case !posted:
+			queue = append(queue, entry)
+		case err == nil:
+			box.Sent[key] = now
+		default:
+			entry.Attempts++
+			entry.LastError = err.Error()
+			if len(entry.Program) > 0 || entry.Attempts >= cfg.Webhooks.attempts() {
+				failed = append(failed, fmt.Sprintf("%v %v", entry.ID, err))
+				continue
+			}
+			backoff := int64(webhookRetry) << uint(entry.Attempts-1)
+			if backoff > 3600 {
+				backoff = 3600
+			}
+			entry.NextAttempt = now + backoff
+			queue = append(queue, entry)
+		}
+	}
+	box.Queue = queue
+
+	for key, sent := range box.Sent {
+		if now-sent > outboxSentKeep {
+			delete(box.Sent, key)
+		}
+	}
+	if err := saveState(outboxState, box); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("gave up on %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// claimOutbox returns the due entries and moves their next attempt past
+// the time it takes to deliver them all.
+func claimOutbox(now int64) ([]outboxEntry, error) {
+	unlock, err := lockState(outboxState)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	box := outbox{}
+	if err := loadState(outboxState, &box); err != nil {
+		return nil, err
+	}
+	due := []outboxEntry{}
+	for _, entry := range box.Queue {
+		if entry.NextAttempt <= now {
+			due = append(due, entry)
+		}
+	}
+	if len(due) < 1 {
+		return nil, nil
+	}
+	lease := now + 1
+	for _, entry := range due {
+		timeout := cfg.Webhooks.timeout()
+		if len(entry.Program) > 0 {
+			timeout = cfg.Hooks.timeout()
+		}
+		lease += int64(timeout / time.Second)
+	}
+	for i := range box.Queue {
+		if box.Queue[i].NextAttempt <= now {
+			box.Queue[i].NextAttempt = lease
+		}
+	}
+	return due, saveState(outboxState, box)
+}
+
+func post(client *http.Client, entry outboxEntry) error {
+	request, err := http.NewRequest(http.MethodPost, entry.URL, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Event-ID", entry.ID)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%v returned %v", entry.URL, response.Status)
+	}
+	return nil
+}
+
+// notifyWebhooks flushes the outbox and prints what is still waiting. With
+// test set a synthetic event is queued first to check the receivers.
+func notifyWebhooks(test bool) {
+	now := time.Now().Unix()
+	if test {
+		host, _ := os.Hostname()
+		t := transition{Time: now, DeviceType: "TEST", Device: host, Field: "test", Old: "", New: "test"}
+		payload := webhookPayload{ID: eventID(t), Host: host, Time: now, DeviceType: t.DeviceType, Device: t.Device, Field: t.Field, New: t.New}
+		entries, err := webhookEntries([]webhookPayload{payload})
+		if err == nil {
+			err = enqueue(entries)
+		}
+		if err != nil {
+			fmt.Printf("Cannot queue test notification\n - %v", err)
+			os.Exit(1)
+		}
+	}
+
+	failed := deliverOutbox(now)
+	if failed != nil {
+		fmt.Printf("Cannot deliver notifications\n - %v\n", failed)
+	}
+	box := outbox{}
+	if err := loadState(outboxState, &box); err != nil {
+		fmt.Printf("Cannot read notification outbox\n - %v", err)
+		os.Exit(1)
+	}
+	for _, entry := range box.Queue {
+		retry := time.Unix(entry.NextAttempt, 0).UTC().Format("2006-01-02 15:04:05")
+		fmt.Printf("%v %v attempts %v, retry at %v: %v\n", entry.ID, entry.URL, entry.Attempts, retry, entry.LastError)
+	}
+	if len(box.Queue) > 0 || failed != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// receiver stands in for a webhook endpoint. It answers with the queued
+// status codes and then with 200, and records the event IDs it was sent.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	events   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, req.Header.Get("X-Event-ID"))
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func setupWebhooks(t *testing.T, attempts int, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	server := httptest.NewServer(r)
+	dir, webhooks := stateDir, cfg.Webhooks
+	stateDir = t.TempDir()
+	cfg.Webhooks = webhookConfig{URLs: []string{server.URL}, Attempts: attempts, Timeout: 5}
+	t.Cleanup(func() {
+		server.Close()
+		stateDir, cfg.Webhooks = dir, webhooks
+	})
+	return r
+}
+
+func loadOutbox(t *testing.T) outbox {
+	box := outbox{}
+	if err := loadState(outboxState, &box); err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+var failedDrive = transition{DeviceType: "PD", Device: "WD-AAA", Field: "state", Old: "Online", New: "Failed"}
+
+func TestWebhookRetry(t *testing.T) {
+	r := setupWebhooks(t, 10, http.StatusInternalServerError)
+	now := int64(1000)
+
+	change := failedDrive
+	change.Time = now
+	if err := queueWebhooks(inventory{}, []transition{change}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverOutbox(now); err != nil {
+		t.Fatal(err)
+	}
+	box := loadOutbox(t)
+	if r.requests() != 1 || len(box.Queue) != 1 {
+		t.Fatalf("after a failed post: %v requests, %v queued, want 1 and 1", r.requests(), len(box.Queue))
+	}
+	if entry := box.Queue[0]; entry.Attempts != 1 || entry.NextAttempt != now+webhookRetry || len(entry.LastError) < 1 {
+		t.Fatalf("failed entry %+v, want attempt 1 retried at %v", entry, now+webhookRetry)
+	}
+
+	if err := deliverOutbox(now + webhookRetry); err != nil {
+		t.Fatal(err)
+	}
+	box = loadOutbox(t)
+	if r.requests() != 2 || len(box.Queue) != 0 || len(box.Sent) != 1 {
+		t.Fatalf("after the retry: %v requests, %v queued, %v sent, want 2, 0 and 1", r.requests(), len(box.Queue), len(box.Sent))
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	r := setupWebhooks(t, 3, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	now := int64(1000)
+
+	if err := queueWebhooks(inventory{}, []transition{failedDrive}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverOutbox(now); err != nil {
+		t.Fatal(err)
+	}
+	// Not due yet, the receiver must not be asked.
+	if err := deliverOutbox(now + webhookRetry - 1); err != nil {
+		t.Fatal(err)
+	}
+	if r.requests() != 1 {
+		t.Fatalf("%v requests before the backoff ran out, want 1", r.requests())
+	}
+
+	if err := deliverOutbox(now + webhookRetry); err != nil {
+		t.Fatal(err)
+	}
+	box := loadOutbox(t)
+	want := now + webhookRetry + 2*webhookRetry
+	if len(box.Queue) != 1 || box.Queue[0].NextAttempt != want {
+		t.Fatalf("after the second failure %+v, want the retry at %v", box.Queue, want)
+	}
+
+	// The last failure is reported, not dropped silently.
+	if err := deliverOutbox(want); err == nil {
+		t.Fatal("giving up on the entry was not reported")
+	}
+	if box := loadOutbox(t); r.requests() != 3 || len(box.Queue) != 0 {
+		t.Fatalf("after the last attempt: %v requests, %v queued, want 3 and 0", r.requests(), len(box.Queue))
+	}
+}
+
+func TestWebhookDedupe(t *testing.T) {
+	r := setupWebhooks(t, 10)
+
+	// The same change queued twice is posted once.
+	change := failedDrive
+	change.Time = 1000
+	for i := 0; i < 2; i++ {
+		if err := queueWebhooks(inventory{}, []transition{change}); err != nil {
+			t.Fatal(err)
+		}
+		if err := deliverOutbox(1000); err != nil {
+			t.Fatal(err)
+		}
+		if r.requests() != 1 {
+			t.Fatalf("queued %v times: %v requests, want 1", i+1, r.requests())
+		}
+	}
+}
+
+func TestWebhookRecurrence(t *testing.T) {
+	r := setupWebhooks(t, 10)
+
+	// Failed, recovered and failed again within the day: every change counts.
+	recovered := failedDrive
+	recovered.Old, recovered.New = "Failed", "Online"
+	for i, change := range []transition{failedDrive, recovered, failedDrive} {
+		change.Time = int64(1000 + 60*i)
+		if err := queueWebhooks(inventory{}, []transition{change}); err != nil {
+			t.Fatal(err)
+		}
+		if err := deliverOutbox(change.Time); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.requests() != 3 || r.events[0] == r.events[2] {
+		t.Fatalf("events %v, want three different IDs", r.events)
+	}
+}
+
+// Hooks go through the outbox: queueing returns at once, the program runs on
+// delivery and a failing one is reported instead of retried.
+func TestHookOutbox(t *testing.T) {
+	setupWebhooks(t, 10)
+	hooks := cfg.Hooks
+	t.Cleanup(func() { cfg.Hooks = hooks })
+	ran := filepath.Join(stateDir, "ran")
+	program := filepath.Join(stateDir, "hook")
+	if err := ioutil.WriteFile(program, []byte("#!/bin/sh\necho \"$1 $ADAPTEC_DEVICE\" >> "+ran+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Hooks = hookConfig{Program: program, Events: map[string]string{"drive-failed": "/bin/false"}}
+
+	change := failedDrive
+	change.Time = 1000
+	if err := queueHooks(inventory{}, []transition{change}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Fatal("the hook ran while it was queued")
+	}
+	err := deliverOutbox(1000)
+	if err == nil || !strings.Contains(err.Error(), "/bin/false") {
+		t.Errorf("failing hook reported as %v", err)
+	}
+	if out, _ := ioutil.ReadFile(ran); string(out) != "drive-failed WD-AAA\n" {
+		t.Errorf("hook ran with %q", out)
+	}
+	if box := loadOutbox(t); len(box.Queue) != 0 {
+		t.Errorf("%v entries still queued, hooks run once", len(box.Queue))
+	}
+}